@@ -2,82 +2,388 @@ package allocrunner
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/schmichael/nomadlet/client/allocrunner/taskrunner"
+	"github.com/schmichael/nomadlet/client/driver"
+	"github.com/schmichael/nomadlet/internal/retry"
 	"github.com/schmichael/nomadlet/internal/rpc"
 	"github.com/schmichael/nomadlet/internal/structs"
 )
 
 type AllocRunner struct {
 	allocID     string
-	modifyIndex uint64
+	allocDir    string
+	modifyIndex atomic.Uint64
 
-	rpc *rpc.Client
+	rpc     *rpc.Client
+	updater StateUpdater
+	stateDB StateDB
+
+	drivers     driver.Registry
+	hostVolumes map[string]*structs.ClientHostVolumeConfig
+
+	// restore is the persisted state to restore or nil
+	restore *structs.AllocState
+
+	// alloc and tasks are set once the alloc has been fetched
+	alloc   *structs.Allocation
+	tasks   []*taskrunner.TaskRunner
+	tasksMu sync.Mutex
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
+	// doneCh is closed when Run exits
+	doneCh chan struct{}
+
 	log *slog.Logger
 }
 
 func New(conf Config) *AllocRunner {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &AllocRunner{
-		allocID:     conf.AllocID,
-		modifyIndex: conf.ModifyIndex,
-		rpc:         conf.RPC,
+	ar := &AllocRunner{
+		allocID:  conf.AllocID,
+		allocDir: conf.AllocDir,
+		rpc:      conf.RPC,
+		updater:  conf.StateUpdater,
+		stateDB:  conf.StateDB,
+		restore:  conf.Restore,
+
+		drivers:     conf.Drivers,
+		hostVolumes: conf.HostVolumes,
 		ctx:         ctx,
 		cancel:      cancel,
+		doneCh:      make(chan struct{}),
 		log:         conf.Logger,
 	}
+	ar.modifyIndex.Store(conf.ModifyIndex)
+	return ar
 }
 
 func (ar *AllocRunner) Run() {
 	defer ar.log.Debug("alloc runner exited")
+	defer close(ar.doneCh)
 
 	var alloc *structs.Allocation
 	var err error
+	if ar.restore != nil {
+		// Restored allocs are stopped by Update if the server stopped them
+		// while nomadlet was down
+		alloc = ar.restore.Alloc
+	}
+
 	// Fetch alloc
+	backoff := retry.Default.Backoff()
 	for ar.ctx.Err() == nil && alloc == nil {
-		alloc, err = ar.rpc.GetAlloc(ar.allocID)
+		alloc, err = ar.rpc.GetAlloc(ar.ctx, ar.allocID)
 		if err != nil {
-			ar.log.Error("error fetch alloc", "error", err)
-			time.Sleep(3 * time.Second)
+			ar.log.Error("error fetch alloc", "error", err, "retryable", rpc.IsRetryable(err))
+			backoff.Wait(ar.ctx)
 			continue
 		}
 	}
-	if ar.ctx.Err() != nil {
+	if ar.ctx.Err() != nil && ar.restore == nil {
+		return
+	}
+
+	if ar.restore == nil && shouldStop(alloc) {
+		ar.log.Debug("not starting alloc stopped by server",
+			"desired_status", alloc.DesiredStatus, "migrate", alloc.DesiredTransition.ShouldMigrate())
+		ar.updater.AllocStateUpdated(&structs.Allocation{
+			ID:                ar.allocID,
+			ClientStatus:      structs.AllocClientStatusComplete,
+			ClientDescription: "Allocation stopped before starting",
+		})
 		return
 	}
 
 	tg := alloc.Group()
 	if tg == nil {
 		ar.log.Error("group not found", "group", alloc.TaskGroup)
+		ar.updater.AllocStateUpdated(&structs.Allocation{
+			ID:                ar.allocID,
+			ClientStatus:      structs.AllocClientStatusFailed,
+			ClientDescription: fmt.Sprintf("Task group %q not found in job", alloc.TaskGroup),
+		})
 		return
 	}
 
+	if err := os.MkdirAll(ar.allocDir, 0o755); err != nil {
+		ar.log.Error("error creating alloc dir", "error", err)
+		ar.updater.AllocStateUpdated(&structs.Allocation{
+			ID:                ar.allocID,
+			ClientStatus:      structs.AllocClientStatusFailed,
+			ClientDescription: fmt.Sprintf("Error creating alloc dir: %v", err),
+		})
+		return
+	}
+
+	mounts := make(map[string][]*structs.MountConfig, len(tg.Tasks))
+	for _, task := range tg.Tasks {
+		m, err := taskMounts(tg, task, ar.hostVolumes)
+		if err != nil && ar.restore == nil {
+			ar.log.Error("error mounting volumes", "task", task.Name, "error", err)
+			ar.updater.AllocStateUpdated(&structs.Allocation{
+				ID:                ar.allocID,
+				ClientStatus:      structs.AllocClientStatusFailed,
+				ClientDescription: fmt.Sprintf("Error mounting volumes for task %q: %v", task.Name, err),
+			})
+			return
+		}
+		if err != nil {
+			// Restored tasks may still be running so restore them without
+			// the volumes that are no longer available
+			ar.log.Warn("error mounting volumes for restored task", "task", task.Name, "error", err)
+		}
+		mounts[task.Name] = m
+	}
+
+	ar.tasksMu.Lock()
+	ar.alloc = alloc
 	for _, task := range tg.Tasks {
 		tc := taskrunner.Config{
-			AllocID: ar.allocID,
-			Task:    task,
-			Logger:  ar.log.With("task", task.Name),
+			AllocID:      ar.allocID,
+			AllocDir:     ar.allocDir,
+			Task:         task,
+			Mounts:       mounts[task.Name],
+			Drivers:      ar.drivers,
+			StateUpdater: ar,
+			Logger:       ar.log.With("task", task.Name),
+		}
+		if ar.restore != nil {
+			tc.Restore = ar.restore.Tasks[task.Name]
 		}
-		tr := taskrunner.New(tc)
-		go tr.Run(ar.ctx)
+		ar.tasks = append(ar.tasks, taskrunner.New(tc))
+	}
+	ar.tasksMu.Unlock()
+
+	// Report all tasks as pending, or their restored state, before starting
+	// any
+	ar.TaskStateUpdated()
+
+	var wg sync.WaitGroup
+	for _, tr := range ar.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tr.Run(ar.ctx)
+		}()
 	}
+	wg.Wait()
+}
+
+// TaskStateUpdated is called by task runners whenever their state changes. It
+// persists the alloc's state and sends the updated alloc status to the state
+// updater.
+func (ar *AllocRunner) TaskStateUpdated() {
+	ar.tasksMu.Lock()
+	alloc := ar.alloc
+	states := make(map[string]*structs.TaskState, len(ar.tasks))
+	local := make(map[string]*structs.TaskLocalState, len(ar.tasks))
+	for _, tr := range ar.tasks {
+		state := tr.State()
+		states[tr.Name()] = state
+		local[tr.Name()] = &structs.TaskLocalState{
+			State:  state,
+			Handle: tr.Handle(),
+		}
+	}
+	ar.tasksMu.Unlock()
+
+	if ar.stateDB != nil && alloc != nil {
+		ar.stateDB.PutAllocState(&structs.AllocState{
+			Alloc: alloc,
+			Tasks: local,
+		})
+	}
+
+	status, desc := clientStatus(states)
+	ar.updater.AllocStateUpdated(&structs.Allocation{
+		ID:                ar.allocID,
+		ClientStatus:      status,
+		ClientDescription: desc,
+		TaskStates:        states,
+	})
+}
+
+// clientStatus derives the alloc's client status from its task states.
+func clientStatus(states map[string]*structs.TaskState) (string, string) {
+	var pending, running, dead, failed, lost bool
+	for _, state := range states {
+		switch state.State {
+		case structs.TaskStateRunning:
+			running = true
+		case structs.TaskStatePending:
+			pending = true
+		case structs.TaskStateDead:
+			switch {
+			case lastEvent(state) == structs.TaskRestoreFailed:
+				lost = true
+			case state.Failed:
+				failed = true
+			default:
+				dead = true
+			}
+		}
+	}
+
+	switch {
+	case lost:
+		return structs.AllocClientStatusLost, "Tasks exited while the client was down"
+	case failed:
+		return structs.AllocClientStatusFailed, "Failed tasks"
+	case running:
+		return structs.AllocClientStatusRunning, "Tasks are running"
+	case pending:
+		return structs.AllocClientStatusPending, "No tasks have started"
+	case dead:
+		return structs.AllocClientStatusComplete, "All tasks have completed"
+	default:
+		return structs.AllocClientStatusPending, "No tasks"
+	}
+}
+
+// lastEvent returns the type of the task's most recent event.
+func lastEvent(state *structs.TaskState) string {
+	if len(state.Events) == 0 {
+		return ""
+	}
+	return state.Events[len(state.Events)-1].Type
+}
+
+// Task returns the task runner for the named task or nil if it does not exist
+// or the alloc has not been fetched yet.
+func (ar *AllocRunner) Task(name string) *taskrunner.TaskRunner {
+	ar.tasksMu.Lock()
+	defer ar.tasksMu.Unlock()
+	for _, tr := range ar.tasks {
+		if tr.Name() == name {
+			return tr
+		}
+	}
+	return nil
+}
+
+// Tasks returns the alloc's task runners.
+func (ar *AllocRunner) Tasks() []*taskrunner.TaskRunner {
+	ar.tasksMu.Lock()
+	defer ar.tasksMu.Unlock()
+	return slices.Clone(ar.tasks)
+}
+
+// Alloc returns the alloc as last fetched from servers or nil if it has not
+// been fetched yet.
+func (ar *AllocRunner) Alloc() *structs.Allocation {
+	ar.tasksMu.Lock()
+	defer ar.tasksMu.Unlock()
+	return ar.alloc
+}
+
+func (ar *AllocRunner) setAlloc(alloc *structs.Allocation) {
+	ar.tasksMu.Lock()
+	defer ar.tasksMu.Unlock()
+	// Run sets the initial alloc before starting tasks
+	if ar.alloc != nil {
+		ar.alloc = alloc
+	}
+}
+
+// ID returns the alloc's ID.
+func (ar *AllocRunner) ID() string {
+	return ar.allocID
+}
+
+// AllocDir returns the alloc's directory.
+func (ar *AllocRunner) AllocDir() string {
+	return ar.allocDir
+}
+
+// FinishedAt returns when the alloc's last task finished or the zero time if
+// no tasks ran.
+func (ar *AllocRunner) FinishedAt() time.Time {
+	var finished time.Time
+	for _, tr := range ar.Tasks() {
+		if t := tr.State().FinishedAt; t.After(finished) {
+			finished = t
+		}
+	}
+	return finished
 }
 
 func (ar *AllocRunner) ModifyIndex() uint64 {
-	return ar.modifyIndex
+	return ar.modifyIndex.Load()
 }
 
-func (ar *AllocRunner) Update() {
-	ar.log.Warn("update not implemented")
+// Update is called when the server modifies the alloc. The updated alloc is
+// fetched in the background and stopped if the server no longer wants it
+// running.
+func (ar *AllocRunner) Update(index uint64) {
+	ar.modifyIndex.Store(index)
+
+	go func() {
+		backoff := retry.Default.Backoff()
+		for ar.ctx.Err() == nil {
+			alloc, err := ar.rpc.GetAlloc(ar.ctx, ar.allocID)
+			if err != nil {
+				ar.log.Error("error fetching updated alloc", "error", err, "retryable", rpc.IsRetryable(err))
+				backoff.Wait(ar.ctx)
+				continue
+			}
+			if alloc.AllocModifyIndex < ar.ModifyIndex() {
+				// Stale read; a newer update will follow
+				return
+			}
+			ar.setAlloc(alloc)
+			switch {
+			case shouldStop(alloc):
+				ar.log.Info("alloc stopped by server", "desired_status", alloc.DesiredStatus,
+					"desired_description", alloc.DesiredDescription, "migrate", alloc.DesiredTransition.ShouldMigrate())
+				ar.Stop()
+			case alloc.ClientStatus == structs.AllocClientStatusUnknown:
+				ar.reconnect()
+			}
+			return
+		}
+	}()
 }
 
+// reconnect resends the alloc's state after servers marked it unknown while
+// the client was disconnected so they can reconcile it.
+func (ar *AllocRunner) reconnect() {
+	ar.log.Info("reconnected alloc marked unknown by server")
+	tasks := ar.Tasks()
+	if len(tasks) == 0 {
+		ar.TaskStateUpdated()
+		return
+	}
+	for _, tr := range tasks {
+		tr.EmitEvent(structs.NewTaskEvent(structs.TaskReconnected, "Client reconnected"))
+	}
+}
+
+// shouldStop returns true if the server wants the alloc stopped, either
+// directly or by migrating it. Servers migrate allocs off draining nodes in
+// the order and by the deadline the drain dictates, so the client stops them
+// as soon as they are marked.
+func shouldStop(alloc *structs.Allocation) bool {
+	return alloc.ServerTerminalStatus() || alloc.DesiredTransition.ShouldMigrate()
+}
+
+// Stop kills the alloc's tasks. Use WaitCh to wait for them to exit.
 func (ar *AllocRunner) Stop() {
 	ar.log.Info("stopping")
 	ar.cancel()
 }
+
+// WaitCh returns a channel that is closed when the alloc runner exits, either
+// because its tasks completed or it was stopped.
+func (ar *AllocRunner) WaitCh() <-chan struct{} {
+	return ar.doneCh
+}
@@ -3,12 +3,38 @@ package allocrunner
 import (
 	"log/slog"
 
+	"github.com/schmichael/nomadlet/client/driver"
 	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/structs"
 )
 
+// StateUpdater receives allocation status updates to send to servers.
+type StateUpdater interface {
+	AllocStateUpdated(*structs.Allocation)
+}
+
+// StateDB persists allocation state so tasks can be restored after nomadlet
+// restarts.
+type StateDB interface {
+	PutAllocState(*structs.AllocState)
+}
+
 type Config struct {
-	AllocID     string
-	ModifyIndex uint64
-	RPC         *rpc.Client
-	Logger      *slog.Logger
+	AllocID      string
+	AllocDir     string
+	ModifyIndex  uint64
+	RPC          *rpc.Client
+	StateUpdater StateUpdater
+	StateDB      StateDB
+	Logger       *slog.Logger
+
+	// Drivers are the drivers tasks may use.
+	Drivers driver.Registry
+
+	// HostVolumes are the node's host volumes that tasks may mount.
+	HostVolumes map[string]*structs.ClientHostVolumeConfig
+
+	// Restore is the alloc's persisted state when restoring after nomadlet
+	// restarts.
+	Restore *structs.AllocState
 }
@@ -3,11 +3,33 @@ package taskrunner
 import (
 	"log/slog"
 
+	"github.com/schmichael/nomadlet/client/driver"
 	"github.com/schmichael/nomadlet/internal/structs"
 )
 
+// StateUpdater is notified whenever a task's state changes.
+type StateUpdater interface {
+	TaskStateUpdated()
+}
+
 type Config struct {
 	AllocID string
-	Task    *structs.Task
-	Logger  *slog.Logger
+
+	// AllocDir is the alloc's directory, which must exist.
+	AllocDir string
+
+	Task *structs.Task
+
+	// Mounts are the task's volume mounts resolved to host paths.
+	Mounts []*structs.MountConfig
+
+	// Drivers are the drivers the task's driver is looked up in.
+	Drivers driver.Registry
+
+	StateUpdater StateUpdater
+	Logger       *slog.Logger
+
+	// Restore is the task's persisted state when restoring after nomadlet
+	// restarts.
+	Restore *structs.TaskLocalState
 }
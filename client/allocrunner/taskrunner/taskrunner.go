@@ -2,93 +2,438 @@ package taskrunner
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/schmichael/nomadlet/client/driver"
 	"github.com/schmichael/nomadlet/internal/structs"
 )
 
+const (
+	// defaultKillSignal and defaultKillTimeout are used when the task does
+	// not set kill_signal or kill_timeout.
+	defaultKillSignal  = "SIGINT"
+	defaultKillTimeout = 5 * time.Second
+)
+
+// ErrTaskNotRunning is returned when operating on a task that has not started
+// or has exited.
+var ErrTaskNotRunning = errors.New("task not running")
+
 type TaskRunner struct {
-	allocID string
-	task    *structs.Task
+	allocID  string
+	allocDir string
+	task     *structs.Task
+	mounts   []*structs.MountConfig
+	updater  StateUpdater
+
+	// id identifies the task to its driver. driver is nil if the task's
+	// driver is not supported.
+	id     string
+	driver driver.Driver
+
+	state   *structs.TaskState
+	stateMu sync.Mutex
+
+	// handle is the running task's handle or nil if it is not running.
+	handle           *structs.TaskHandle
+	restartRequested bool
+	handleMu         sync.Mutex
 
 	log *slog.Logger
 }
 
 func New(conf Config) *TaskRunner {
-	return &TaskRunner{
-		allocID: conf.AllocID,
-		task:    conf.Task,
-		log:     conf.Logger,
+	tr := &TaskRunner{
+		allocID:  conf.AllocID,
+		allocDir: conf.AllocDir,
+		task:     conf.Task,
+		mounts:   conf.Mounts,
+		updater:  conf.StateUpdater,
+		id:       conf.AllocID + "/" + conf.Task.Name,
+		driver:   conf.Drivers[conf.Task.Driver],
+		state: &structs.TaskState{
+			State:  structs.TaskStatePending,
+			Events: []*structs.TaskEvent{structs.NewTaskEvent(structs.TaskReceived, "Task received by client")},
+		},
+		log: conf.Logger,
+	}
+	if conf.Restore != nil && conf.Restore.State != nil {
+		tr.state = conf.Restore.State.Copy()
+		tr.handle = conf.Restore.Handle
 	}
+	return tr
+}
+
+// Name returns the name of the task.
+func (tr *TaskRunner) Name() string {
+	return tr.task.Name
+}
+
+// State returns a copy of the task's current state.
+func (tr *TaskRunner) State() *structs.TaskState {
+	tr.stateMu.Lock()
+	defer tr.stateMu.Unlock()
+	return tr.state.Copy()
+}
+
+// setState transitions the task to a new state, records the event, and
+// notifies the state updater.
+func (tr *TaskRunner) setState(state string, event *structs.TaskEvent) {
+	tr.stateMu.Lock()
+	now := time.Now()
+	if state == structs.TaskStateRunning && tr.state.State != structs.TaskStateRunning {
+		tr.state.StartedAt = now
+	}
+	if state == structs.TaskStateDead && tr.state.State != structs.TaskStateDead {
+		tr.state.FinishedAt = now
+	}
+	tr.state.State = state
+	if event != nil {
+		if event.FailsTask {
+			tr.state.Failed = true
+		}
+		tr.state.Events = append(tr.state.Events, event)
+	}
+	tr.stateMu.Unlock()
+
+	if tr.updater != nil {
+		tr.updater.TaskStateUpdated()
+	}
+}
+
+// EmitEvent records an event without changing the task's state.
+func (tr *TaskRunner) EmitEvent(event *structs.TaskEvent) {
+	tr.stateMu.Lock()
+	state := tr.state.State
+	tr.stateMu.Unlock()
+	tr.setState(state, event)
+}
+
+// fail marks the task as dead and failed.
+func (tr *TaskRunner) fail(eventType string, err error) {
+	tr.log.Error("task failed", "event", eventType, "error", err)
+	ev := structs.NewTaskEvent(eventType, err.Error())
+	ev.FailsTask = true
+	tr.setState(structs.TaskStateDead, ev)
 }
 
 func (tr *TaskRunner) Run(ctx context.Context) {
 	defer tr.log.Info("task runner exited")
 
-	// msgpack was a mistake
-	commandBytes, _ := tr.task.Config["command"].([]byte)
-	command := string(commandBytes)
-	if command == "" {
-		tr.log.Error("missing command")
+	if tr.State().State == structs.TaskStateDead {
+		// Restored after exiting
 		return
 	}
-	path, err := exec.LookPath(command)
-	if err != nil {
-		tr.log.Error("error finding command", "error", err)
+
+	if tr.driver == nil {
+		tr.fail(structs.TaskDriverFailure, fmt.Errorf("unsupported driver %q", tr.task.Driver))
 		return
 	}
 
-	var args []string
-	argsI, ok := tr.task.Config["args"]
-	if ok {
-		argsIslice, ok := argsI.([]any)
-		if !ok {
-			tr.log.Error("invalid type for args: %T", argsI)
-			return
-		}
-		for i, ai := range argsIslice {
-			a, ok := ai.([]byte)
-			if !ok {
-				tr.log.Error("invalid type for arg element %d: %T", i, ai)
+	// Reattach to the task started before nomadlet restarted
+	waitCh, ok := tr.recover(ctx)
+	if !ok {
+		return
+	}
+
+	for {
+		if waitCh == nil {
+			if ctx.Err() != nil {
+				tr.setState(structs.TaskStateDead, structs.NewTaskEvent(structs.TaskKilled, "Task killed before starting"))
 				return
 			}
-			args = append(args, string(a))
+
+			handle, err := tr.driver.StartTask(tr.taskConfig())
+			if err != nil {
+				tr.fail(structs.TaskDriverFailure, err)
+				return
+			}
+			tr.setHandle(handle)
+			tr.setState(structs.TaskStateRunning, structs.NewTaskEvent(structs.TaskStarted, "Task started by client"))
+
+			waitCh, err = tr.driver.WaitTask(ctx, tr.id)
+			if err != nil {
+				tr.fail(structs.TaskDriverFailure, fmt.Errorf("error waiting on task: %w", err))
+				return
+			}
+		}
+
+		var res *driver.ExitResult
+		select {
+		case res = <-waitCh:
+		case <-ctx.Done():
+			tr.kill()
+			tr.driver.DestroyTask(tr.id)
+			tr.setHandle(nil)
+			tr.setState(structs.TaskStateDead, structs.NewTaskEvent(structs.TaskKilled, "Task successfully killed"))
+			return
+		}
+		tr.driver.DestroyTask(tr.id)
+		tr.setHandle(nil)
+		waitCh = nil
+
+		ev := exitEvent(res)
+		if !tr.restarting() {
+			tr.setState(structs.TaskStateDead, ev)
+			return
 		}
+
+		// Killed for a restart so the exit is not a failure
+		ev.FailsTask = false
+		tr.setState(structs.TaskStatePending, ev)
+		tr.restarted()
 	}
+}
+
+// taskConfig returns the task's config for its driver.
+func (tr *TaskRunner) taskConfig() *driver.TaskConfig {
+	return &driver.TaskConfig{
+		ID:         tr.id,
+		AllocID:    tr.allocID,
+		Name:       tr.task.Name,
+		Config:     tr.task.Config,
+		Env:        tr.env(),
+		Mounts:     tr.mounts,
+		StdoutPath: LogPath(tr.allocDir, tr.task.Name, "stdout"),
+		StderrPath: LogPath(tr.allocDir, tr.task.Name, "stderr"),
+	}
+}
 
+// kill stops the task with its kill signal, force killing it after its kill
+// timeout.
+func (tr *TaskRunner) kill() {
+	sig := defaultKillSignal
+	if tr.task.KillSignal != "" {
+		if _, err := driver.ParseSignal(tr.task.KillSignal); err != nil {
+			tr.log.Warn("invalid kill signal; using default", "error", err, "default", sig)
+		} else {
+			sig = tr.task.KillSignal
+		}
+	}
+	timeout := tr.task.KillTimeout
+	if timeout <= 0 {
+		timeout = defaultKillTimeout
+	}
+
+	tr.EmitEvent(structs.NewTaskEvent(structs.TaskKilling,
+		fmt.Sprintf("Sent %s. Waiting %s before force killing", sig, timeout)))
+
+	if err := tr.driver.StopTask(tr.id, timeout, sig); err != nil {
+		tr.log.Warn("error stopping task", "error", err)
+	}
+}
+
+// LogPath returns the path of a task's stdout or stderr log within its alloc
+// dir.
+func LogPath(allocDir, task, logType string) string {
+	return filepath.Join(allocDir, fmt.Sprintf("%s.%s.log", task, logType))
+}
+
+// env returns the task's environment variables in os/exec form.
+//
+// The host path of each mounted volume is exposed as NOMAD_VOLUME_<volume>
+// for drivers that cannot bind mount volumes at their destination, such as
+// raw_exec, which also cannot enforce read-only mounts.
+func (tr *TaskRunner) env() []string {
 	var env []string
+	for _, m := range tr.mounts {
+		env = append(env, volumeEnvVar(m.Volume)+"="+m.HostPath)
+	}
 	for k, v := range tr.task.Env {
 		env = append(env, k+"="+v)
 	}
+	return env
+}
+
+// volumeEnvVar returns the name of the environment variable exposing a
+// volume's path. Characters not valid in variable names are replaced with
+// underscores.
+func volumeEnvVar(volume string) string {
+	name := []byte("NOMAD_VOLUME_" + strings.ToUpper(volume))
+	for i, c := range name {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// setHandle records the running task's handle. Must be called before
+// updating the task's state so the handle is persisted with it.
+func (tr *TaskRunner) setHandle(handle *structs.TaskHandle) {
+	tr.handleMu.Lock()
+	defer tr.handleMu.Unlock()
+	tr.handle = handle
+}
+
+// Handle returns the handle of the running task or nil.
+func (tr *TaskRunner) Handle() *structs.TaskHandle {
+	tr.handleMu.Lock()
+	defer tr.handleMu.Unlock()
+	if tr.handle == nil {
+		return nil
+	}
+	h := *tr.handle
+	return &h
+}
+
+// running returns true if the task has been started and not exited.
+func (tr *TaskRunner) running() bool {
+	tr.handleMu.Lock()
+	defer tr.handleMu.Unlock()
+	return tr.handle != nil
+}
 
-	stdout, err := os.OpenFile(fmt.Sprintf("%s-%s.stdout.log", tr.allocID, tr.task.Name),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+// recover returns a channel that receives the task's exit if the task was
+// restored with a handle. The task is marked dead and false is returned if it
+// exited while nomadlet was down.
+func (tr *TaskRunner) recover(ctx context.Context) (<-chan *driver.ExitResult, bool) {
+	handle := tr.Handle()
+	if handle == nil {
+		return nil, true
+	}
+
+	if err := tr.driver.RecoverTask(tr.id, handle); err != nil {
+		tr.log.Warn("task exited while nomadlet was down", "pid", handle.PID, "error", err)
+		tr.setHandle(nil)
+		ev := structs.NewTaskEvent(structs.TaskRestoreFailed, "Task exited while the client was down")
+		ev.FailsTask = true
+		tr.setState(structs.TaskStateDead, ev)
+		return nil, false
+	}
+	waitCh, err := tr.driver.WaitTask(ctx, tr.id)
 	if err != nil {
-		tr.log.Error("unable to create stdout log", "error", err)
-		return
+		tr.fail(structs.TaskDriverFailure, fmt.Errorf("error waiting on task: %w", err))
+		return nil, false
+	}
+
+	tr.log.Info("reattached to task", "pid", handle.PID)
+	return waitCh, true
+}
+
+// Signal sends a signal to the running task.
+func (tr *TaskRunner) Signal(name string) error {
+	if _, err := driver.ParseSignal(name); err != nil {
+		return err
+	}
+	if !tr.running() {
+		return ErrTaskNotRunning
 	}
-	defer stdout.Close()
 
-	stderr, err := os.OpenFile(fmt.Sprintf("%s-%s.stderr.log", tr.allocID, tr.task.Name),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	tr.EmitEvent(structs.NewTaskEvent(structs.TaskSignaling, "Task being sent signal "+name))
+	err := tr.driver.SignalTask(tr.id, name)
+	if errors.Is(err, driver.ErrTaskNotFound) {
+		return ErrTaskNotRunning
+	}
+	return err
+}
+
+// Stats returns the resource usage of the running task and when it started.
+func (tr *TaskRunner) Stats() (*driver.TaskResourceUsage, time.Time, error) {
+	if !tr.running() {
+		return nil, time.Time{}, ErrTaskNotRunning
+	}
+	status, err := tr.driver.InspectTask(tr.id)
+	if errors.Is(err, driver.ErrTaskNotFound) {
+		return nil, time.Time{}, ErrTaskNotRunning
+	}
 	if err != nil {
-		tr.log.Error("unable to create stderr log", "error", err)
-		return
+		return nil, time.Time{}, err
+	}
+	if status.State != driver.TaskStateRunning {
+		return nil, time.Time{}, ErrTaskNotRunning
+	}
+
+	usage, err := tr.driver.TaskStats(tr.id)
+	if errors.Is(err, driver.ErrTaskNotFound) {
+		return nil, time.Time{}, ErrTaskNotRunning
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return usage, status.StartedAt, nil
+}
+
+// Restart kills the running task and starts it again.
+func (tr *TaskRunner) Restart() error {
+	tr.handleMu.Lock()
+	running := tr.handle != nil
+	if running {
+		tr.restartRequested = true
+	}
+	tr.handleMu.Unlock()
+	if !running {
+		return ErrTaskNotRunning
 	}
-	defer stderr.Close()
 
-	cmd := &exec.Cmd{
-		Path:   path,
-		Args:   args,
-		Env:    env,
-		Stdout: stdout,
-		Stderr: stderr,
+	// Events must be emitted without holding handleMu as the alloc runner reads
+	// the task's handle when persisting its state.
+	tr.EmitEvent(structs.NewTaskEvent(structs.TaskRestartSignal, "User requested task to restart"))
+	err := tr.driver.StopTask(tr.id, 0, "SIGKILL")
+	if errors.Is(err, driver.ErrTaskNotFound) {
+		return ErrTaskNotRunning
 	}
+	return err
+}
+
+// restarting returns true and clears the request if a restart was requested.
+func (tr *TaskRunner) restarting() bool {
+	tr.handleMu.Lock()
+	defer tr.handleMu.Unlock()
+	r := tr.restartRequested
+	tr.restartRequested = false
+	return r
+}
+
+// restarted records a restart in the task's state.
+func (tr *TaskRunner) restarted() {
+	tr.stateMu.Lock()
+	tr.state.Restarts++
+	tr.state.LastRestart = time.Now()
+	tr.stateMu.Unlock()
+	tr.setState(structs.TaskStatePending, structs.NewTaskEvent(structs.TaskRestarting, "Task restarting"))
+}
 
-	if err := cmd.Run(); err != nil {
-		tr.log.Error("error running command", "error", err)
+// ExecCommand returns a command that runs in the task's environment. Only
+// valid while the task is running.
+func (tr *TaskRunner) ExecCommand(ctx context.Context, args []string) (*exec.Cmd, error) {
+	if len(args) == 0 {
+		return nil, errors.New("command is required")
+	}
+	if tr.State().State != structs.TaskStateRunning {
+		return nil, ErrTaskNotRunning
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = tr.env()
+	return cmd, nil
+}
+
+// exitEvent builds the Terminated event for a task's exit.
+func exitEvent(res *driver.ExitResult) *structs.TaskEvent {
+	ev := structs.NewTaskEvent(structs.TaskTerminated, "")
+	switch {
+	case errors.Is(res.Err, driver.ErrExitUnknown):
+		ev.Message = "Exit code unknown for task reattached after client restart"
+		ev.DisplayMessage = ev.Message
+		return ev
+	case res.Err != nil:
+		ev.Details["error"] = res.Err.Error()
+	}
+	ev.ExitCode = res.ExitCode
+	ev.Signal = res.Signal
+	ev.FailsTask = !res.Successful()
+	ev.DisplayMessage = fmt.Sprintf("Exit Code: %d", ev.ExitCode)
+	if ev.Signal != 0 {
+		ev.DisplayMessage += fmt.Sprintf(", Signal: %d", ev.Signal)
 	}
+	ev.Message = ev.DisplayMessage
+	return ev
 }
@@ -0,0 +1,175 @@
+package taskrunner
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/driver"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// newTestTaskRunner returns a task runner for task using the drivers
+// supported by nomadlet.
+func newTestTaskRunner(t *testing.T, task *structs.Task) *TaskRunner {
+	t.Helper()
+	logger := slog.New(slog.DiscardHandler)
+	drivers, err := driver.NewRegistry(logger, nil)
+	if err != nil {
+		t.Fatalf("error creating drivers: %v", err)
+	}
+	return New(Config{
+		AllocID:  "alloc-1",
+		AllocDir: t.TempDir(),
+		Task:     task,
+		Drivers:  drivers,
+		Logger:   logger,
+	})
+}
+
+// run runs the task runner until it exits.
+func run(t *testing.T, tr *TaskRunner, ctx context.Context) {
+	t.Helper()
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		tr.Run(ctx)
+	}()
+	select {
+	case <-doneCh:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("task runner did not exit")
+	}
+}
+
+// lastEvent returns the task's last event.
+func lastEvent(state *structs.TaskState) *structs.TaskEvent {
+	return state.Events[len(state.Events)-1]
+}
+
+func TestTaskRunner_UnsupportedDriver(t *testing.T) {
+	tr := newTestTaskRunner(t, &structs.Task{Name: "t", Driver: "docker"})
+	run(t, tr, context.Background())
+
+	state := tr.State()
+	if state.State != structs.TaskStateDead || !state.Failed {
+		t.Fatalf("expected task to be dead and failed, got %+v", state)
+	}
+	ev := lastEvent(state)
+	if ev.Type != structs.TaskDriverFailure || !strings.Contains(ev.DisplayMessage, `unsupported driver "docker"`) {
+		t.Errorf("expected unsupported driver failure, got %+v", ev)
+	}
+	if _, _, err := tr.Stats(); err != ErrTaskNotRunning {
+		t.Errorf("expected stats of failed task to be unavailable, got %v", err)
+	}
+}
+
+func TestTaskRunner_ExitCode(t *testing.T) {
+	cases := []struct {
+		command string
+		failed  bool
+	}{
+		{"true", false},
+		{"false", true},
+	}
+	for _, tc := range cases {
+		tr := newTestTaskRunner(t, &structs.Task{
+			Name:   "t",
+			Driver: "raw_exec",
+			Config: map[string]any{"command": tc.command},
+		})
+		run(t, tr, context.Background())
+
+		state := tr.State()
+		if state.State != structs.TaskStateDead || state.Failed != tc.failed {
+			t.Errorf("%s: expected task to be dead with failed=%t, got %+v", tc.command, tc.failed, state)
+		}
+		if tr.Handle() != nil {
+			t.Errorf("%s: expected handle to be cleared", tc.command)
+		}
+	}
+}
+
+func TestTaskRunner_Stats(t *testing.T) {
+	tr := newTestTaskRunner(t, &structs.Task{
+		Name:   "t",
+		Driver: "raw_exec",
+		Config: map[string]any{"command": "sleep", "args": []any{"30"}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		tr.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-doneCh
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.State().State != structs.TaskStateRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("task did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A process has no memory of its own until it execs
+	var startedAt time.Time
+	for {
+		usage, started, err := tr.Stats()
+		if err != nil {
+			t.Fatalf("error getting stats: %v", err)
+		}
+		if usage.RSSBytes > 0 {
+			startedAt = started
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected rss to be measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if time.Since(startedAt) > 5*time.Second || startedAt.After(time.Now()) {
+		t.Errorf("expected task to have just started, started at %s", startedAt)
+	}
+}
+
+func TestTaskRunner_KillSignal(t *testing.T) {
+	tr := newTestTaskRunner(t, &structs.Task{
+		Name:        "t",
+		Driver:      "raw_exec",
+		Config:      map[string]any{"command": "sleep", "args": []any{"30"}},
+		KillSignal:  "SIGTERM",
+		KillTimeout: time.Second,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		tr.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tr.State().State != structs.TaskStateRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("task did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-doneCh
+
+	var killing *structs.TaskEvent
+	for _, ev := range tr.State().Events {
+		if ev.Type == structs.TaskKilling {
+			killing = ev
+		}
+	}
+	if killing == nil || !strings.HasPrefix(killing.DisplayMessage, "Sent SIGTERM.") {
+		t.Errorf("expected killing event naming SIGTERM, got %+v", killing)
+	}
+}
@@ -0,0 +1,35 @@
+package allocrunner
+
+import (
+	"fmt"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// taskMounts resolves a task's volume mounts to the paths of the node's host
+// volumes. A mount is read-only if the mount, the group's volume request, or
+// the host volume is read-only.
+func taskMounts(tg *structs.TaskGroup, task *structs.Task, hostVolumes map[string]*structs.ClientHostVolumeConfig) ([]*structs.MountConfig, error) {
+	var mounts []*structs.MountConfig
+	for _, vm := range task.VolumeMounts {
+		req, ok := tg.Volumes[vm.Volume]
+		if !ok {
+			return nil, fmt.Errorf("volume %q not found in group", vm.Volume)
+		}
+		if req.Type != structs.VolumeTypeHost {
+			return nil, fmt.Errorf("volume %q has unsupported type %q", vm.Volume, req.Type)
+		}
+		hv, ok := hostVolumes[req.Source]
+		if !ok {
+			return nil, fmt.Errorf("host volume %q not found on node", req.Source)
+		}
+		mounts = append(mounts, &structs.MountConfig{
+			Volume:          vm.Volume,
+			TaskPath:        vm.Destination,
+			HostPath:        hv.Path,
+			Readonly:        vm.ReadOnly || req.ReadOnly || hv.ReadOnly,
+			PropagationMode: vm.PropagationMode,
+		})
+	}
+	return mounts, nil
+}
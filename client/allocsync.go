@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/retry"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// allocSyncInterval is how often pending alloc updates are batched and
+	// sent to servers.
+	allocSyncInterval = 200 * time.Millisecond
+)
+
+// AllocStateUpdated queues an allocation's client status to be sent to
+// servers. Updates for the same allocation are coalesced so only the latest
+// is sent.
+func (c *Client) AllocStateUpdated(alloc *structs.Allocation) {
+	alloc.NodeID = c.getNode().ID
+
+	c.allocUpdatesMu.Lock()
+	defer c.allocUpdatesMu.Unlock()
+	c.allocUpdates[alloc.ID] = alloc
+}
+
+// allocSync periodically sends batches of queued alloc updates to servers
+// with Node.UpdateAlloc.
+func (c *Client) allocSync(ctx context.Context) {
+	defer c.log.Debug("alloc sync exited")
+
+	backoff := retry.Default.Backoff()
+	ticker := time.NewTicker(allocSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := c.syncAllocs(ctx)
+		if err != nil {
+			c.log.Error("error updating allocs; retrying", "error", err, "allocs", n)
+			ticker.Reset(backoff.Next())
+			continue
+		}
+
+		if n > 0 {
+			c.log.Debug("updated allocs", "allocs", n)
+		}
+		backoff.Reset()
+		ticker.Reset(allocSyncInterval)
+	}
+}
+
+// syncAllocs sends all queued alloc updates in a single batch and returns the
+// number sent. Failed updates are requeued unless a newer update for the same
+// allocation was queued in the meantime.
+func (c *Client) syncAllocs(ctx context.Context) (int, error) {
+	c.allocUpdatesMu.Lock()
+	if len(c.allocUpdates) == 0 {
+		c.allocUpdatesMu.Unlock()
+		return 0, nil
+	}
+	batch := make([]*structs.Allocation, 0, len(c.allocUpdates))
+	for _, alloc := range c.allocUpdates {
+		batch = append(batch, alloc)
+	}
+	c.allocUpdates = map[string]*structs.Allocation{}
+	c.allocUpdatesMu.Unlock()
+
+	if err := c.rpc.NodeUpdateAlloc(ctx, batch); err != nil {
+		c.allocUpdatesMu.Lock()
+		for _, alloc := range batch {
+			if _, ok := c.allocUpdates[alloc.ID]; !ok {
+				c.allocUpdates[alloc.ID] = alloc
+			}
+		}
+		c.allocUpdatesMu.Unlock()
+		return len(batch), err
+	}
+	return len(batch), nil
+}
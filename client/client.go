@@ -2,23 +2,96 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/schmichael/nomadlet/client/allocrunner"
+	"github.com/schmichael/nomadlet/client/driver"
+	"github.com/schmichael/nomadlet/internal/retry"
 	"github.com/schmichael/nomadlet/internal/rpc"
 	"github.com/schmichael/nomadlet/internal/structs"
 	"github.com/schmichael/nomadlet/internal/uuid"
 )
 
+const (
+	// allocWatchMaxWait is the maximum time a blocking query for allocations
+	// waits for changes before returning.
+	allocWatchMaxWait = 5 * time.Minute
+)
+
 type Client struct {
-	node  *structs.Node
-	rpc   *rpc.Client
-	state *structs.State
+	// config and node are replaced when reloading. Use Config and getNode to
+	// read them.
+	config   *structs.Config
+	configMu sync.Mutex
+	node     *structs.Node
+	rpc      *rpc.Client
+
+	// drivers run tasks and are fingerprinted onto the node.
+	drivers driver.Registry
+
+	// nodeUpdateCh is signalled when the node changes. registerCh makes the
+	// heartbeat re-register the node once node changes have been batched.
+	nodeUpdateCh chan struct{}
+	registerCh   chan struct{}
+
+	state   *structs.State
+	stateMu sync.Mutex
+
+	// hostVolumesMu serializes creating and deleting dynamic host volumes,
+	// which run plugins without holding stateMu.
+	hostVolumesMu sync.Mutex
+
+	// allocs are the alloc runners keyed by alloc ID. Terminal allocs are
+	// kept until garbage collected.
+	allocs   map[string]*allocrunner.AllocRunner
+	allocsMu sync.RWMutex
+
+	// serverAllocs are the alloc IDs last listed by servers for this node or
+	// nil before the first list. Guarded by allocsMu.
+	serverAllocs map[string]uint64
+
+	// allocStateMu serializes writing and removing alloc state files so a
+	// write racing garbage collection cannot persist a removed alloc. It is
+	// acquired before allocsMu.
+	allocStateMu sync.Mutex
+
+	// allocUpdates are pending alloc status updates keyed by alloc ID
+	allocUpdates   map[string]*structs.Allocation
+	allocUpdatesMu sync.Mutex
+
+	// eligibility and drain are the node's scheduling eligibility and drain
+	// strategy as last reported by servers. nodeModifyIndex is the node's
+	// ModifyIndex when drain was fetched.
+	eligibility     string
+	drain           *structs.DrainStrategy
+	nodeModifyIndex uint64
+	nodeMu          sync.Mutex
+
+	// watchResetCh restarts the alloc watcher from index 0 after
+	// re-registering
+	watchResetCh chan struct{}
 
-	log *slog.Logger
+	// lastHeartbeat is when the last heartbeat succeeded. disconnected is
+	// true once heartbeats have failed for longer than heartbeatTTL.
+	lastHeartbeat time.Time
+	heartbeatTTL  time.Duration
+	disconnected  bool
+	heartbeatMu   sync.Mutex
+
+	// stopHeartbeat and stopWatch stop the heartbeat and alloc watcher
+	// during shutdown. Set by Run once registered.
+	stopHeartbeat context.CancelFunc
+	stopWatch     context.CancelFunc
+	stopMu        sync.Mutex
+
+	log      *slog.Logger
+	logLevel *slog.LevelVar
 }
 
 func NewClient(config *structs.Config) (*Client, error) {
@@ -37,67 +110,132 @@ func NewClient(config *structs.Config) (*Client, error) {
 		}
 	}
 
-	node, err := structs.MakeNode(state, config)
+	if err := os.MkdirAll(config.AllocDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating alloc dir: %w", err)
+	}
+
+	level, err := structs.ParseLogLevel(config.LogLevel)
 	if err != nil {
 		return nil, err
 	}
+	logLevel := &slog.LevelVar{}
+	logLevel.Set(level)
 
 	rpcClient, err := rpc.NewClient(state, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Client{
-		node:  node,
-		rpc:   rpcClient,
-		state: state,
+	c := &Client{
+		config: config,
+		rpc:    rpcClient,
+		state:  state,
+
+		allocs:       map[string]*allocrunner.AllocRunner{},
+		allocUpdates: map[string]*structs.Allocation{},
+		watchResetCh: make(chan struct{}, 1),
+		nodeUpdateCh: make(chan struct{}, 1),
+		registerCh:   make(chan struct{}, 1),
+
 		log: slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
 			AddSource: false,
-			Level:     slog.LevelDebug,
+			Level:     logLevel,
 		})),
-	}, nil
+		logLevel: logLevel,
+	}
+	c.drivers, err = driver.NewRegistry(c.log, config.Plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	c.node, err = c.makeNode(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serve RPCs sent by servers over our sessions
+	rpcServer := rpc.NewServer(c.log.With("component", "rpc_server"))
+	if err := c.registerEndpoints(rpcServer); err != nil {
+		return nil, fmt.Errorf("error registering rpc endpoints: %w", err)
+	}
+	rpcClient.SetServer(rpcServer)
+
+	return c, nil
 }
 
+// Run registers the node and runs its allocs until ctx is done.
 func (c *Client) Run(ctx context.Context) {
 	go func() {
 		<-ctx.Done()
 		c.log.Debug("interrupt received")
 	}()
 
+	// Reattach to tasks left running by a previous nomadlet before
+	// registering so they are supervised while registration is retried
+	c.restoreAllocs()
+
 	// 1. Register
 	var err error
 	var regResp *rpc.NodeUpdateResponse
+	backoff := retry.Default.Backoff()
 	for ctx.Err() == nil {
-		regResp, err = c.rpc.NodeRegister(c.node)
+		regResp, err = c.rpc.NodeRegister(ctx, c.getNode())
 		if err == nil {
 			break
 		}
-		c.log.Error("error registering node... retrying", "error", err)
+		if errors.Is(err, rpc.ErrNodeSecretMismatch) {
+			if regResp, err = c.registerNewNode(ctx, err); err == nil {
+				break
+			}
+		}
+		c.log.Error("error registering node... retrying", "error", err, "retryable", rpc.IsRetryable(err))
 
-		time.Sleep(10 * time.Second)
+		if backoff.Wait(ctx) != nil {
+			break
+		}
 	}
 	if ctx.Err() != nil {
 		return
 	}
 
+	c.updateServers(regResp.Servers)
+	c.updateNodeStatus(ctx, regResp)
+
+	// Shutdown stops heartbeating and watching allocs independently of ctx
+	hbCtx, stopHeartbeat := context.WithCancel(ctx)
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	c.stopMu.Lock()
+	c.stopHeartbeat = stopHeartbeat
+	c.stopWatch = stopWatch
+	c.stopMu.Unlock()
+
 	// 2. Heartbeat
-	go c.heartbeat(ctx, regResp.HeartbeatTTL)
+	c.heartbeatOK(regResp.HeartbeatTTL)
+	go c.heartbeat(hbCtx, regResp.HeartbeatTTL)
+	go c.watchDisconnect(hbCtx)
+	go c.watchNodeUpdates(hbCtx)
+	go c.fingerprint(hbCtx)
 
 	c.log.Info("registered node", "resp", regResp)
 
-	// 3. Run allocs
-	go c.fetchAllocs(ctx)
+	// 3. Run allocs and report their status
+	go c.allocSync(ctx)
+	go c.fetchAllocs(watchCtx)
+	go c.gc(watchCtx)
 
 	// 9. Ping in a loop because this was the first code I wrote, and I'm too
 	//    attached to it to delete it.
 	for ctx.Err() == nil {
 		start := time.Now()
-		if err := c.rpc.StatusPing(); err != nil {
+		if err := c.rpc.StatusPing(ctx); err != nil {
 			c.log.Error("error pinging server", "error", err)
 		} else {
 			c.log.Debug("pinged server!", "latency", time.Since(start))
 		}
-		time.Sleep(10 * time.Second)
+		select {
+		case <-ctx.Done():
+		case <-time.After(10 * time.Second):
+		}
 	}
 	c.log.Debug("client exited")
 }
@@ -105,73 +243,256 @@ func (c *Client) Run(ctx context.Context) {
 func (c *Client) heartbeat(ctx context.Context, initial time.Duration) {
 	defer c.log.Debug("heartbeat exited")
 
+	backoff := retry.Heartbeat.Backoff()
 	timer := time.NewTimer(initial)
+	registerPending := false
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-timer.C:
+		case <-c.registerCh:
+			registerPending = true
 		}
 
-		resp, err := c.rpc.NodeUpdateStatus()
+		var resp *rpc.NodeUpdateResponse
+		var err error
+		if registerPending {
+			// Registering also heartbeats
+			node := *c.getNode()
+			node.Status = structs.NodeStatusReady
+			if resp, err = c.rpc.NodeRegister(ctx, &node); err == nil {
+				c.log.Info("re-registered node to update it")
+				registerPending = false
+			}
+		} else {
+			resp, err = c.rpc.NodeUpdateStatus(ctx, structs.NodeStatusReady)
+		}
+		switch {
+		case errors.Is(err, rpc.ErrNodeSecretMismatch):
+			if resp, err = c.registerNewNode(ctx, err); err == nil {
+				registerPending = false
+			}
+		case errors.Is(err, rpc.ErrNodeNotFound):
+			resp, err = c.reregister(ctx, "Node re-registered after servers did not recognize it", err)
+		}
 		if err != nil {
-			c.log.Error("failed to heartbeat; retrying", "error", err)
-			//TODO jitter
-			timer.Reset(3 * time.Second)
+			c.log.Error("failed to heartbeat; retrying", "error", err, "retryable", rpc.IsRetryable(err))
+			c.heartbeatFailed()
+			timer.Reset(backoff.Next())
 			continue
 		}
+		backoff.Reset()
+		c.heartbeatOK(resp.HeartbeatTTL)
 
-		c.log.Debug("heartbeat", "initial", initial, "next", resp.HeartbeatTTL)
+		c.log.Debug("heartbeat", "initial", initial, "next", resp.HeartbeatTTL,
+			"eligibility", resp.SchedulingEligibility, "draining", c.Draining())
+		c.updateServers(resp.Servers)
+		c.updateNodeStatus(ctx, resp)
 		timer.Reset(resp.HeartbeatTTL)
 	}
 }
 
+// reregister registers the node again after servers lost it, such as when
+// the node was garbage collected or server state was restored from a snapshot,
+// and emits a node event with message. The alloc watcher is restarted since
+// alloc indexes may no longer be valid.
+func (c *Client) reregister(ctx context.Context, message string, reason error) (*rpc.NodeUpdateResponse, error) {
+	c.log.Warn("re-registering node", "reason", reason)
+
+	resp, err := c.rpc.NodeRegister(ctx, c.getNode())
+	if err != nil {
+		return nil, fmt.Errorf("error re-registering node: %w", err)
+	}
+	c.log.Info("re-registered node")
+
+	// Forget the node state known before re-registering
+	c.nodeMu.Lock()
+	c.nodeModifyIndex = 0
+	c.drain = nil
+	c.nodeMu.Unlock()
+
+	select {
+	case c.watchResetCh <- struct{}{}:
+	default:
+	}
+
+	event := &structs.NodeEvent{
+		Message:   message,
+		Subsystem: structs.NodeEventSubsystemCluster,
+		Details:   map[string]string{"reason": reason.Error()},
+		Timestamp: time.Now(),
+	}
+	if err := c.rpc.NodeEmitEvents(ctx, []*structs.NodeEvent{event}); err != nil {
+		c.log.Error("error emitting re-registration node event", "error", err)
+	}
+
+	return resp, nil
+}
+
+// registerNewNode registers the node under a new ID and secret after servers
+// reject the node's secret. Another node is likely registered with the node's
+// ID, so registering with it again would take that node over.
+func (c *Client) registerNewNode(ctx context.Context, reason error) (*rpc.NodeUpdateResponse, error) {
+	c.stateMu.Lock()
+	oldID := c.state.NodeID
+	c.state.NodeID = uuid.Generate()
+	c.state.NodeSecret = uuid.Generate()
+	id, secret := c.state.NodeID, c.state.NodeSecret
+	if err := c.state.Store(c.Config().StatePath); err != nil {
+		c.log.Error("error persisting new node ID", "error", err)
+	}
+	c.nodeMu.Lock()
+	node := c.node.Copy()
+	node.ID = id
+	node.SecretID = secret
+	c.node = node
+	c.nodeMu.Unlock()
+	c.stateMu.Unlock()
+
+	c.rpc.SetNode(id, secret)
+	c.log.Warn("servers rejected node secret; registering as a new node",
+		"old_node_id", oldID, "node_id", id)
+	return c.reregister(ctx, "Node registered with a new ID after servers rejected the secret of node "+oldID, reason)
+}
+
+// updateServers merges the servers returned by the cluster into the RPC
+// client's server list and persists them so a restarted client can reach the
+// cluster even if the configured servers are gone.
+func (c *Client) updateServers(servers []*rpc.NodeServerInfo) {
+	if !c.rpc.SetServers(servers) {
+		return
+	}
+
+	var addrs []string
+	for _, s := range servers {
+		if s != nil && s.RPCAdvertiseAddr != "" {
+			addrs = append(addrs, s.RPCAdvertiseAddr)
+		}
+	}
+	c.log.Debug("updated servers", "servers", c.rpc.Servers())
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	if slices.Equal(addrs, c.state.Servers) {
+		return
+	}
+	c.state.Servers = addrs
+	if err := c.state.Store(c.Config().StatePath); err != nil {
+		c.log.Error("error persisting servers", "error", err)
+	}
+}
+
 func (c *Client) fetchAllocs(ctx context.Context) {
 	defer c.log.Debug("no longer fetching allocs")
 
-	allocs := map[string]*allocrunner.AllocRunner{}
-
+	// synced is false until allocs are reconciled with the first response
+	// after starting or resetting the index. The server may answer with the
+	// same index, such as 0 when it has no allocs, and restored allocs must
+	// still be reconciled.
+	var index uint64
+	synced := false
+	backoff := retry.Default.Backoff()
 	for ctx.Err() == nil {
-		allocIndexes, err := c.rpc.NodeGetClientAllocs()
+		// Interrupt the blocking query if the node re-registers
+		queryCtx, cancel := context.WithCancel(ctx)
+		resetCh := make(chan bool, 1)
+		go func() {
+			select {
+			case <-c.watchResetCh:
+				cancel()
+				resetCh <- true
+			case <-queryCtx.Done():
+				resetCh <- false
+			}
+		}()
+		allocIndexes, err := c.rpc.NodeGetClientAllocs(queryCtx, index, allocWatchMaxWait)
+		cancel()
+		if <-resetCh {
+			c.log.Info("node re-registered; refetching allocs", "prev", index)
+			index = 0
+			synced = false
+			continue
+		}
 		if err != nil {
-			c.log.Error("error fetching client allocs", "error", err)
-			time.Sleep(3 * time.Second)
+			if ctx.Err() != nil {
+				break
+			}
+			c.log.Error("error fetching client allocs", "error", err, "retryable", rpc.IsRetryable(err))
+			if backoff.Wait(ctx) != nil {
+				break
+			}
 			continue
 		}
+		backoff.Reset()
 
-		for allocID, index := range allocIndexes.Allocs {
-			ar, ok := allocs[allocID]
-			switch {
-			case !ok:
-				c.log.Debug("starting alloc", "alloc", allocID)
-				// New alloc
-				ac := allocrunner.Config{
-					AllocID:     allocID,
-					ModifyIndex: index,
-					RPC:         c.rpc,
-					Logger:      c.log.With("alloc_id", allocID),
-				}
-				ar := allocrunner.New(ac)
-				allocs[allocID] = ar
-				go ar.Run()
-			case ar.ModifyIndex() < index:
-				// Updated allocs
-				ar.Update()
-			default:
-				// No change
-			}
+		switch {
+		case allocIndexes.Index < index:
+			// The index went backwards due to a leader change or snapshot
+			// restore. Start over from the beginning so no changes are missed.
+			c.log.Warn("alloc index reset; refetching allocs", "prev", index, "index", allocIndexes.Index)
+			index = 0
+			synced = false
+			continue
+		case allocIndexes.Index == index && synced:
+			// Blocking query timed out without changes
+			continue
 		}
+		index = allocIndexes.Index
+		synced = true
 
-		// Stop missing allocs
-		for allocID, ar := range allocs {
-			if _, ok := allocIndexes.Allocs[allocID]; !ok {
-				c.log.Debug("stopping alloc", "alloc", allocID)
-				ar.Stop()
-				delete(allocs, allocID)
-			}
+		c.runAllocs(allocIndexes.Allocs)
+	}
+}
+
+// runAllocs starts new allocs, updates changed allocs, and stops allocs the
+// server no longer assigns to this node. allocIndexes maps alloc IDs to their
+// AllocModifyIndex.
+func (c *Client) runAllocs(allocIndexes map[string]uint64) {
+	c.allocsMu.Lock()
+	defer c.allocsMu.Unlock()
+
+	c.serverAllocs = allocIndexes
+
+	for allocID, index := range allocIndexes {
+		ar, ok := c.allocs[allocID]
+		switch {
+		case !ok:
+			c.log.Debug("starting alloc", "alloc", allocID)
+			// New alloc
+			ar := c.newAllocRunner(allocID, index, nil)
+			c.allocs[allocID] = ar
+			go ar.Run()
+		case ar.ModifyIndex() < index:
+			// Updated allocs
+			ar.Update(index)
+		default:
+			// No change
 		}
+	}
+
+	// Stop missing allocs. They are kept until garbage collected.
+	for allocID, ar := range c.allocs {
+		if _, ok := allocIndexes[allocID]; ok {
+			continue
+		}
+		select {
+		case <-ar.WaitCh():
+		default:
+			c.log.Debug("stopping alloc", "alloc", allocID)
+			ar.Stop()
+		}
+	}
+}
 
-		//TODO lol
-		time.Sleep(3 * time.Second)
+// getAllocRunner returns the runner for an alloc or an error if the alloc is
+// not running on this node.
+func (c *Client) getAllocRunner(allocID string) (*allocrunner.AllocRunner, error) {
+	c.allocsMu.RLock()
+	defer c.allocsMu.RUnlock()
+	ar, ok := c.allocs[allocID]
+	if !ok {
+		return nil, fmt.Errorf("Unknown allocation %q", allocID)
 	}
+	return ar, nil
 }
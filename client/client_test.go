@@ -0,0 +1,280 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/rpc/rpctest"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// testConfig returns a config using a temporary directory for nomadlet's
+// files.
+func testConfig(t *testing.T, servers ...string) *structs.Config {
+	t.Helper()
+	dir := t.TempDir()
+	config := structs.DefaultConfig()
+	config.Servers = servers
+	config.StatePath = filepath.Join(dir, "state.json")
+	config.AllocDir = filepath.Join(dir, "alloc")
+	config.HostVolumesDir = filepath.Join(dir, "host_volumes")
+	config.HostVolumePluginDir = filepath.Join(dir, "host_volume_plugins")
+	config.LogLevel = "warn"
+	return config
+}
+
+// runTestClient starts a fake server and a client registered with it. The
+// client leaves when the test ends.
+func runTestClient(t *testing.T) (*rpctest.Server, *Client) {
+	t.Helper()
+
+	srv, err := rpctest.NewServer()
+	if err != nil {
+		t.Fatalf("error starting server: %v", err)
+	}
+	srv.SetHeartbeatTTL(200 * time.Millisecond)
+
+	c, err := NewClient(testConfig(t, srv.Addr))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		c.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancelShutdown()
+		c.Shutdown(shutdownCtx, true)
+		cancel()
+		<-doneCh
+		srv.Close()
+	})
+
+	waitFor(t, 5*time.Second, "node to be ready", func() bool {
+		node := srv.Node(c.getNode().ID)
+		return node != nil && node.Status == structs.NodeStatusReady
+	})
+	return srv, c
+}
+
+// waitFor fails the test if cond does not return true within timeout.
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestClient_RegisterHeartbeat(t *testing.T) {
+	srv, c := runTestClient(t)
+
+	node := srv.Node(c.getNode().ID)
+	if node.SecretID != c.getNode().SecretID {
+		t.Errorf("expected secret %q, got %q", c.getNode().SecretID, node.SecretID)
+	}
+	if node.Drivers["raw_exec"] == nil || !node.Drivers["raw_exec"].Healthy {
+		t.Errorf("expected healthy raw_exec driver, got %+v", node.Drivers)
+	}
+
+	// Heartbeats mark the node ready again after it is disconnected
+	if err := srv.DisconnectNode(node.ID); err != nil {
+		t.Fatalf("error disconnecting node: %v", err)
+	}
+	waitFor(t, 5*time.Second, "heartbeat", func() bool {
+		return srv.Node(node.ID).Status == structs.NodeStatusReady
+	})
+}
+
+func TestClient_RunAlloc(t *testing.T) {
+	srv, c := runTestClient(t)
+	nodeID := c.getNode().ID
+
+	srv.UpsertAlloc(&structs.Allocation{
+		ID:            "alloc-1",
+		NodeID:        nodeID,
+		TaskGroup:     "g",
+		DesiredStatus: structs.AllocDesiredStatusRun,
+		Job: &structs.Job{
+			ID: "j",
+			TaskGroups: []*structs.TaskGroup{{
+				Name: "g",
+				Tasks: []*structs.Task{{
+					Name:   "t",
+					Driver: "raw_exec",
+					Config: map[string]any{"command": "true"},
+				}},
+			}},
+		},
+	})
+
+	waitFor(t, 10*time.Second, "alloc to complete", func() bool {
+		alloc := srv.Alloc("alloc-1")
+		return alloc.ClientStatus == structs.AllocClientStatusComplete
+	})
+	state := srv.Alloc("alloc-1").TaskStates["t"]
+	if state == nil || state.State != structs.TaskStateDead || state.Failed {
+		t.Fatalf("expected task to be dead and not failed, got %+v", state)
+	}
+}
+
+func TestClient_SecretMismatch(t *testing.T) {
+	srv, c := runTestClient(t)
+
+	// Another node registers with the client's node ID. Heartbeats fail
+	// meanwhile so the client does not re-register first.
+	srv.SetHook(func(method string) error {
+		if method == "Node.UpdateStatus" {
+			return errors.New("No cluster leader")
+		}
+		return nil
+	})
+	node := c.getNode().Copy()
+	srv.DeleteNode(node.ID)
+	state := &structs.State{NodeID: node.ID, NodeSecret: "other-secret"}
+	other, err := rpc.NewClient(state, c.Config())
+	if err != nil {
+		t.Fatalf("error creating rpc client: %v", err)
+	}
+	node.SecretID = state.NodeSecret
+	if _, err := other.NodeRegister(context.Background(), node); err != nil {
+		t.Fatalf("error registering other node: %v", err)
+	}
+	srv.SetHook(nil)
+
+	// The client registers as a new node rather than taking over the other
+	waitFor(t, 5*time.Second, "new node to be ready", func() bool {
+		n := srv.Node(c.getNode().ID)
+		return n != nil && n.ID != node.ID && n.Status == structs.NodeStatusReady
+	})
+	newNode := srv.Node(c.getNode().ID)
+	if newNode.SecretID == node.SecretID || newNode.SecretID == state.NodeSecret {
+		t.Errorf("expected a new secret, got %q", newNode.SecretID)
+	}
+	if got := srv.Node(node.ID).SecretID; got != state.NodeSecret {
+		t.Errorf("expected other node's secret, got %q", got)
+	}
+	if len(newNode.Events) == 0 || !strings.Contains(newNode.Events[len(newNode.Events)-1].Message, node.ID) {
+		t.Errorf("expected event naming the old node ID, got %+v", newNode.Events)
+	}
+
+	// The new ID is persisted for restarts
+	saved, err := structs.StateLoad(c.Config().StatePath)
+	if err != nil {
+		t.Fatalf("error loading state: %v", err)
+	}
+	if saved.NodeID != newNode.ID || saved.NodeSecret != newNode.SecretID {
+		t.Errorf("expected state to have node %s, got %s", newNode.ID, saved.NodeID)
+	}
+}
+
+func TestClient_AllocStats(t *testing.T) {
+	srv, c := runTestClient(t)
+	nodeID := c.getNode().ID
+
+	srv.UpsertAlloc(&structs.Allocation{
+		ID:            "alloc-1",
+		NodeID:        nodeID,
+		TaskGroup:     "g",
+		DesiredStatus: structs.AllocDesiredStatusRun,
+		Job: &structs.Job{
+			ID: "j",
+			TaskGroups: []*structs.TaskGroup{{
+				Name: "g",
+				Tasks: []*structs.Task{{
+					Name:   "t",
+					Driver: "raw_exec",
+					Config: map[string]any{"command": "sleep", "args": []any{"30"}},
+				}},
+			}},
+		},
+	})
+	waitFor(t, 10*time.Second, "alloc to run", func() bool {
+		return srv.Alloc("alloc-1").ClientStatus == structs.AllocClientStatusRunning
+	})
+
+	// A process has no memory of its own until it execs
+	var resp rpc.AllocStatsResponse
+	var err error
+	waitFor(t, 5*time.Second, "task rss", func() bool {
+		resp = rpc.AllocStatsResponse{}
+		err = srv.ClientRPC(nodeID, "ClientAllocations.Stats", &rpc.AllocStatsRequest{AllocID: "alloc-1"}, &resp)
+		return err != nil || resp.Stats.Tasks["t"] != nil && resp.Stats.Tasks["t"].ResourceUsage.MemoryStats.RSS > 0
+	})
+	if err != nil {
+		t.Fatalf("error getting stats: %v", err)
+	}
+	task := resp.Stats.Tasks["t"]
+	if resp.Stats.ResourceUsage.MemoryStats.RSS != task.ResourceUsage.MemoryStats.RSS {
+		t.Errorf("expected alloc rss to total its tasks' rss")
+	}
+
+	err = srv.ClientRPC(nodeID, "ClientAllocations.Stats", &rpc.AllocStatsRequest{AllocID: "alloc-1", Task: "missing"}, &resp)
+	if err == nil || !strings.Contains(err.Error(), `Failed to find task "missing"`) {
+		t.Errorf("expected unknown task error, got %v", err)
+	}
+}
+
+func TestClient_Metrics(t *testing.T) {
+	srv, c := runTestClient(t)
+	nodeID := c.getNode().ID
+
+	// gauges fetches the gauges served over HTTP by name
+	gauges := func() map[string]float32 {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		c.HTTPHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/v1/metrics", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var summary MetricsSummary
+		if err := json.Unmarshal(rec.Body.Bytes(), &summary); err != nil {
+			t.Fatalf("error decoding metrics: %v", err)
+		}
+		m := map[string]float32{}
+		for _, g := range summary.Gauges {
+			if g.Labels["node_id"] != nodeID {
+				t.Errorf("expected node_id label %q, got %v", nodeID, g.Labels)
+			}
+			m[g.Name] = g.Value
+		}
+		return m
+	}
+
+	m := gauges()
+	if m["nomad.client.draining"] != 0 || m["nomad.client.scheduling_eligible"] != 1 {
+		t.Errorf("expected eligible node not draining, got %v", m)
+	}
+	if _, ok := m["nomad.client.drain_deadline_seconds"]; ok {
+		t.Errorf("expected no drain deadline, got %v", m)
+	}
+
+	if err := srv.DrainNode(nodeID, &structs.DrainSpec{Deadline: time.Hour}, false); err != nil {
+		t.Fatalf("error draining node: %v", err)
+	}
+	waitFor(t, 5*time.Second, "drain", func() bool {
+		return c.Draining() && c.Eligibility() == structs.NodeSchedulingIneligible
+	})
+	m = gauges()
+	if m["nomad.client.draining"] != 1 || m["nomad.client.scheduling_eligible"] != 0 {
+		t.Errorf("expected ineligible draining node, got %v", m)
+	}
+	if d := m["nomad.client.drain_deadline_seconds"]; d <= 0 || d > 3600 {
+		t.Errorf("expected drain deadline within an hour, got %v", d)
+	}
+}
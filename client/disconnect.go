@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/allocrunner"
+)
+
+const (
+	// disconnectCheckInterval is how often allocs are checked for whether
+	// they should be stopped while disconnected.
+	disconnectCheckInterval = time.Second
+)
+
+// heartbeatOK records a successful heartbeat. Allocs servers marked unknown
+// while the client was disconnected are resynced when the alloc watcher sees
+// the update.
+func (c *Client) heartbeatOK(ttl time.Duration) {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	if c.disconnected {
+		c.log.Info("reconnected to servers", "disconnected_for", time.Since(c.lastHeartbeat))
+		c.disconnected = false
+	}
+	c.lastHeartbeat = time.Now()
+	c.heartbeatTTL = ttl
+}
+
+// heartbeatFailed records a failed heartbeat. Once heartbeats have failed for
+// longer than the TTL servers consider the node down.
+func (c *Client) heartbeatFailed() {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	if !c.disconnected && time.Since(c.lastHeartbeat) > c.heartbeatTTL {
+		c.log.Warn("heartbeat TTL expired; disconnected from servers", "last_heartbeat", c.lastHeartbeat)
+		c.disconnected = true
+	}
+}
+
+// disconnectedFor returns how long heartbeats have been failing since the last
+// success, or false if the last heartbeat succeeded.
+func (c *Client) disconnectedFor() (time.Duration, bool) {
+	c.heartbeatMu.Lock()
+	defer c.heartbeatMu.Unlock()
+	if !c.disconnected {
+		return 0, false
+	}
+	return time.Since(c.lastHeartbeat), true
+}
+
+// watchDisconnect stops allocs that should not keep running while the client
+// is disconnected once their stop timeout has passed. Allocs without a stop
+// timeout keep running and are reconciled by servers when the client
+// reconnects.
+func (c *Client) watchDisconnect(ctx context.Context) {
+	defer c.log.Debug("no longer watching for disconnects")
+
+	ticker := time.NewTicker(disconnectCheckInterval)
+	defer ticker.Stop()
+
+	// stopped tracks allocs already stopped so they are only stopped once
+	stopped := map[string]struct{}{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		since, ok := c.disconnectedFor()
+		if !ok {
+			clear(stopped)
+			continue
+		}
+
+		for _, ar := range c.runningAllocs() {
+			alloc := ar.Alloc()
+			if alloc == nil {
+				continue
+			}
+			if _, ok := stopped[alloc.ID]; ok {
+				continue
+			}
+			timeout, ok := c.disconnectStopTimeout(ar)
+			if !ok || since < timeout {
+				continue
+			}
+			c.log.Warn("stopping alloc after client disconnect", "alloc_id", alloc.ID, "timeout", timeout)
+			stopped[alloc.ID] = struct{}{}
+			ar.Stop()
+		}
+	}
+}
+
+// disconnectStopTimeout returns how long an alloc may run while the client is
+// disconnected or false if it should keep running. Groups that configure
+// disconnect behavior override the client's StopAfterClientDisconnect.
+func (c *Client) disconnectStopTimeout(ar *allocrunner.AllocRunner) (time.Duration, bool) {
+	tg := ar.Alloc().Group()
+	if tg == nil {
+		return 0, false
+	}
+	if timeout := tg.GetDisconnectStopTimeout(); timeout != nil {
+		return *timeout, true
+	}
+	if tg.GetDisconnectLostTimeout() > 0 {
+		// Servers keep the alloc until it is lost and reconcile on reconnect
+		return 0, false
+	}
+	if stopAfter := c.Config().StopAfterClientDisconnect; stopAfter > 0 {
+		return stopAfter, true
+	}
+	return 0, false
+}
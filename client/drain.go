@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+
+	"github.com/schmichael/nomadlet/internal/rpc"
+)
+
+// updateNodeStatus records the node's scheduling eligibility from a register
+// or heartbeat response. Heartbeats do not include the node's drain strategy,
+// so the node is fetched whenever its ModifyIndex changes.
+func (c *Client) updateNodeStatus(ctx context.Context, resp *rpc.NodeUpdateResponse) {
+	c.nodeMu.Lock()
+	prev := c.eligibility
+	c.eligibility = resp.SchedulingEligibility
+	modified := resp.NodeModifyIndex > c.nodeModifyIndex
+	c.nodeMu.Unlock()
+
+	if prev != "" && prev != resp.SchedulingEligibility {
+		c.log.Info("scheduling eligibility changed", "from", prev, "to", resp.SchedulingEligibility)
+	}
+	if !modified {
+		return
+	}
+
+	node, err := c.rpc.NodeGetNode(ctx)
+	if err != nil {
+		// Retried on the next heartbeat as nodeModifyIndex is unchanged
+		c.log.Error("error fetching node drain status", "error", err, "retryable", rpc.IsRetryable(err))
+		return
+	}
+
+	c.nodeMu.Lock()
+	if node.ModifyIndex < c.nodeModifyIndex {
+		// Stale read
+		c.nodeMu.Unlock()
+		return
+	}
+	c.nodeModifyIndex = node.ModifyIndex
+	prevDrain := c.drain
+	c.drain = node.DrainStrategy
+	c.nodeMu.Unlock()
+
+	switch {
+	case prevDrain == nil && node.DrainStrategy != nil:
+		drain := node.DrainStrategy
+		c.log.Info("node drain started", "deadline", drain.Deadline,
+			"force_deadline", drain.ForceDeadline, "ignore_system_jobs", drain.IgnoreSystemJobs)
+	case prevDrain != nil && node.DrainStrategy == nil:
+		c.log.Info("node drain complete")
+	}
+}
+
+// Eligibility returns the node's scheduling eligibility as last reported by
+// servers.
+func (c *Client) Eligibility() string {
+	c.nodeMu.Lock()
+	defer c.nodeMu.Unlock()
+	return c.eligibility
+}
+
+// Draining returns true if servers are draining the node. Servers stop the
+// node's allocs by marking them for migration.
+func (c *Client) Draining() bool {
+	c.nodeMu.Lock()
+	defer c.nodeMu.Unlock()
+	return c.drain != nil
+}
@@ -0,0 +1,160 @@
+// Package driver runs tasks. Each driver implements a task's driver, such as
+// raw_exec.
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// ErrTaskNotFound is returned when operating on a task the driver is not
+// running.
+var ErrTaskNotFound = errors.New("task not found")
+
+// Driver starts and manages tasks. Tasks are identified by TaskConfig.ID.
+type Driver interface {
+	// Fingerprint returns the driver's health to report on the node.
+	Fingerprint() *structs.DriverInfo
+
+	// StartTask starts a task and returns the handle to persist so the task
+	// can be recovered after nomadlet restarts.
+	StartTask(config *TaskConfig) (*structs.TaskHandle, error)
+
+	// RecoverTask reattaches to a task started before nomadlet restarted.
+	// Returns an error if the task is no longer running.
+	RecoverTask(id string, handle *structs.TaskHandle) error
+
+	// WaitTask returns a channel that receives the task's exit result once
+	// it exits. Nothing is sent if ctx is done first.
+	WaitTask(ctx context.Context, id string) (<-chan *ExitResult, error)
+
+	// StopTask sends the signal to the task and waits up to timeout for it to
+	// exit before killing it. Returns once the task has exited.
+	StopTask(id string, timeout time.Duration, signal string) error
+
+	// DestroyTask forgets a task that has exited.
+	DestroyTask(id string)
+
+	SignalTask(id string, signal string) error
+
+	// InspectTask returns the task's state and when it started. StartedAt is
+	// zero if it cannot be determined for a recovered task.
+	InspectTask(id string) (*TaskStatus, error)
+
+	// TaskStats returns the task's current resource usage.
+	TaskStats(id string) (*TaskResourceUsage, error)
+}
+
+// TaskConfig is a task to start.
+type TaskConfig struct {
+	// ID is unique to the task within the client.
+	ID      string
+	AllocID string
+	Name    string
+
+	// Config is the task's driver config.
+	Config map[string]any
+
+	// Env is in os/exec form.
+	Env []string
+
+	// Mounts are the task's volume mounts. Drivers that isolate tasks bind
+	// mount them at their task path.
+	Mounts []*structs.MountConfig
+
+	// StdoutPath and StderrPath are the log files the task's output is
+	// appended to.
+	StdoutPath string
+	StderrPath string
+}
+
+// ExitResult is how a task exited. Err is set if the exit status could not
+// be determined.
+type ExitResult struct {
+	ExitCode int
+	Signal   int
+	Err      error
+}
+
+// Successful returns true if the task exited with status 0.
+func (r *ExitResult) Successful() bool {
+	return r.ExitCode == 0 && r.Signal == 0 && r.Err == nil
+}
+
+// Task states reported by InspectTask
+const (
+	TaskStateRunning = "running"
+	TaskStateExited  = "exited"
+)
+
+// TaskStatus is a task's state within the driver.
+type TaskStatus struct {
+	ID        string
+	State     string
+	StartedAt time.Time
+
+	// ExitResult is set once the task has exited.
+	ExitResult *ExitResult
+}
+
+// TaskResourceUsage is a task's resource usage at Timestamp.
+type TaskResourceUsage struct {
+	RSSBytes   uint64
+	UserTime   time.Duration
+	SystemTime time.Duration
+	Timestamp  time.Time
+}
+
+// Registry is the drivers keyed by name.
+type Registry map[string]Driver
+
+// factories create each supported driver from its plugin config, which may be
+// nil. Config errors must start with the offending key within the config.
+var factories = map[string]func(logger *slog.Logger, config map[string]any) (Driver, error){
+	"raw_exec": func(logger *slog.Logger, config map[string]any) (Driver, error) {
+		d, err := NewRawExec(logger, config)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	},
+}
+
+// NewRegistry returns the drivers supported by nomadlet. plugins are the
+// drivers' configs keyed by driver name. Errors name the offending config
+// key.
+func NewRegistry(logger *slog.Logger, plugins map[string]map[string]any) (Registry, error) {
+	names := slices.Sorted(maps.Keys(factories))
+	for name := range plugins {
+		if factories[name] == nil {
+			return nil, fmt.Errorf("plugin.%s: unknown driver; must be one of: %s", name, strings.Join(names, ", "))
+		}
+	}
+
+	r := make(Registry, len(factories))
+	for _, name := range names {
+		d, err := factories[name](logger.With("driver", name), plugins[name])
+		if err != nil {
+			return nil, fmt.Errorf("plugin.%s.config.%w", name, err)
+		}
+		r[name] = d
+	}
+	return r, nil
+}
+
+// Fingerprint returns the health of each driver keyed by name.
+func (r Registry) Fingerprint() map[string]*structs.DriverInfo {
+	drivers := make(map[string]*structs.DriverInfo, len(r))
+	for name, d := range r {
+		drivers[name] = d.Fingerprint()
+	}
+	return drivers
+}
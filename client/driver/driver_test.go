@@ -0,0 +1,56 @@
+package driver
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewRegistry_Config(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	r, err := NewRegistry(logger, nil)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	if info := r.Fingerprint()["raw_exec"]; !info.Detected || !info.Healthy {
+		t.Errorf("expected raw_exec to be enabled by default, got %+v", info)
+	}
+
+	r, err = NewRegistry(logger, map[string]map[string]any{
+		"raw_exec": {"enabled": false},
+	})
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	if info := r.Fingerprint()["raw_exec"]; info.Detected || info.Healthy || len(info.Attributes) > 0 {
+		t.Errorf("expected raw_exec to be disabled, got %+v", info)
+	}
+	if _, err := r["raw_exec"].StartTask(&TaskConfig{ID: "t", Config: map[string]any{"command": "true"}}); err == nil {
+		t.Errorf("expected disabled raw_exec to not start tasks")
+	}
+
+	cases := []struct {
+		plugins map[string]map[string]any
+		err     string
+	}{
+		{
+			plugins: map[string]map[string]any{"docker": {}},
+			err:     "plugin.docker: unknown driver; must be one of: raw_exec",
+		},
+		{
+			plugins: map[string]map[string]any{"raw_exec": {"enabled": "yes"}},
+			err:     "plugin.raw_exec.config.enabled: must be true or false",
+		},
+		{
+			plugins: map[string]map[string]any{"raw_exec": {"enabled": true, "no_cgroups": true}},
+			err:     "plugin.raw_exec.config.no_cgroups: unknown key; must be one of: enabled",
+		},
+	}
+	for _, tc := range cases {
+		_, err := NewRegistry(logger, tc.plugins)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error %q, got %v", tc.err, err)
+		}
+	}
+}
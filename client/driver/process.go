@@ -0,0 +1,105 @@
+package driver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// reattachPollInterval is how often reattached processes are checked for
+	// having exited since they cannot be waited on.
+	reattachPollInterval = time.Second
+)
+
+// ErrExitUnknown is the exit error of a recovered task. Only a process's
+// parent can learn its exit status.
+var ErrExitUnknown = errors.New("exit status unknown after reattaching")
+
+// processStat returns the fields of a process's /proc/<pid>/stat following
+// its command name, so fields[0] is the process state (field 3).
+func processStat(pid int) ([]string, error) {
+	buf, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name may contain spaces and parens, so fields are counted
+	// from the end of it.
+	stat := string(buf)
+	i := strings.LastIndexByte(stat, ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat for pid %d", pid)
+	}
+	fields := strings.Fields(stat[i+1:])
+
+	// fields[21] is the last field read: rss (field 24)
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat for pid %d", pid)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return nil, fmt.Errorf("pid %d has exited", pid)
+	}
+	return fields, nil
+}
+
+// processStartTime returns the start time of a process in clock ticks since
+// boot. A process that reuses a PID has a different start time.
+func processStartTime(pid int) (uint64, error) {
+	fields, err := processStat(pid)
+	if err != nil {
+		return 0, err
+	}
+	// fields[19] is the start time (field 22)
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// processStartedAt converts a process start time in clock ticks since boot to
+// the time the process started.
+func processStartedAt(startTime uint64) (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			btime, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid boot time %q", v)
+			}
+			since := time.Duration(startTime) * time.Second / clockTicks
+			return time.Unix(btime, 0).Add(since), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.New("boot time not found in /proc/stat")
+}
+
+// processAlive returns true if the process with the given PID and start time
+// is still running.
+func processAlive(pid int, startTime uint64) bool {
+	st, err := processStartTime(pid)
+	return err == nil && st == startTime
+}
+
+// watchProcess closes doneCh once a process that is not a child of nomadlet
+// exits.
+func watchProcess(pid int, startTime uint64, doneCh chan<- struct{}) {
+	ticker := time.NewTicker(reattachPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !processAlive(pid, startTime) {
+			close(doneCh)
+			return
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// clockTicks is the kernel's USER_HZ, the unit of /proc/<pid>/stat times.
+	// It is 100 on all architectures Go supports on Linux.
+	clockTicks = 100
+)
+
+// RawExec runs tasks as unisolated processes on the host. Tasks run in their
+// own process group which is killed when stopping the task.
+type RawExec struct {
+	config RawExecConfig
+
+	tasks   map[string]*rawExecTask
+	tasksMu sync.Mutex
+
+	log *slog.Logger
+}
+
+// RawExecConfig is raw_exec's plugin config.
+type RawExecConfig struct {
+	// Enabled allows tasks to use raw_exec. Unlike in Nomad it defaults to
+	// true as raw_exec is nomadlet's only driver.
+	Enabled bool
+}
+
+// rawExecConfigKeys are the keys of raw_exec's plugin config.
+var rawExecConfigKeys = []string{"enabled"}
+
+// rawExecTask is a process started or recovered by RawExec.
+type rawExecTask struct {
+	pid       int
+	startedAt time.Time
+
+	// doneCh is closed once the process exits and exit is set.
+	doneCh chan struct{}
+	exit   *ExitResult
+}
+
+// NewRawExec returns a raw_exec driver configured by its plugin config, which
+// may be nil.
+func NewRawExec(logger *slog.Logger, config map[string]any) (*RawExec, error) {
+	d := &RawExec{
+		config: RawExecConfig{Enabled: true},
+		tasks:  map[string]*rawExecTask{},
+		log:    logger,
+	}
+	for _, k := range slices.Sorted(maps.Keys(config)) {
+		switch k {
+		case "enabled":
+			enabled, ok := config[k].(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be true or false", k)
+			}
+			d.config.Enabled = enabled
+		default:
+			return nil, fmt.Errorf("%s: unknown key; must be one of: %s", k, strings.Join(rawExecConfigKeys, ", "))
+		}
+	}
+	return d, nil
+}
+
+func (d *RawExec) Fingerprint() *structs.DriverInfo {
+	if !d.config.Enabled {
+		return &structs.DriverInfo{
+			HealthDescription: "disabled",
+		}
+	}
+	return &structs.DriverInfo{
+		Attributes:        map[string]string{"driver.raw_exec": "1"},
+		Detected:          true,
+		Healthy:           true,
+		HealthDescription: "never felt better",
+	}
+}
+
+// StartTask runs the task's command with its args.
+func (d *RawExec) StartTask(config *TaskConfig) (*structs.TaskHandle, error) {
+	if !d.config.Enabled {
+		return nil, errors.New("raw_exec is disabled")
+	}
+	command, _ := config.Config["command"].(string)
+	if command == "" {
+		return nil, errors.New("missing command")
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("error finding command: %w", err)
+	}
+
+	// argv[0] is the command as given in the task's config
+	args := []string{command}
+	if argsI, ok := config.Config["args"]; ok {
+		argsIslice, ok := argsI.([]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid type for args: %T", argsI)
+		}
+		for i, ai := range argsIslice {
+			a, ok := ai.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid type for arg element %d: %T", i, ai)
+			}
+			args = append(args, a)
+		}
+	}
+
+	stdout, err := os.OpenFile(config.StdoutPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create stdout log: %w", err)
+	}
+	defer stdout.Close()
+
+	stderr, err := os.OpenFile(config.StderrPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create stderr log: %w", err)
+	}
+	defer stderr.Close()
+
+	cmd := &exec.Cmd{
+		Path:   path,
+		Args:   args,
+		Env:    config.Env,
+		Stdout: stdout,
+		Stderr: stderr,
+
+		// Run in a separate process group so signals sent to nomadlet's
+		// group, such as ctrl-c, are not delivered to tasks.
+		SysProcAttr: &syscall.SysProcAttr{Setpgid: true},
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error starting command: %w", err)
+	}
+
+	t := &rawExecTask{
+		pid:       cmd.Process.Pid,
+		startedAt: time.Now(),
+		doneCh:    make(chan struct{}),
+	}
+	go func() {
+		t.exit = exitResult(cmd.Wait())
+		close(t.doneCh)
+	}()
+
+	handle := &structs.TaskHandle{PID: t.pid}
+	startTime, err := processStartTime(t.pid)
+	if err != nil {
+		d.log.Warn("unable to determine process start time; task will not be restored", "task_id", config.ID, "error", err)
+	} else {
+		handle.StartTime = startTime
+	}
+
+	d.tasksMu.Lock()
+	d.tasks[config.ID] = t
+	d.tasksMu.Unlock()
+	return handle, nil
+}
+
+// RecoverTask reattaches to the task's process if it is still running. The
+// exit status of recovered tasks is unknown as they are not children of
+// nomadlet.
+func (d *RawExec) RecoverTask(id string, handle *structs.TaskHandle) error {
+	if handle.StartTime == 0 || !processAlive(handle.PID, handle.StartTime) {
+		return fmt.Errorf("process %d is not running", handle.PID)
+	}
+
+	startedAt, err := processStartedAt(handle.StartTime)
+	if err != nil {
+		d.log.Warn("unable to determine when recovered task started", "task_id", id, "error", err)
+	}
+
+	t := &rawExecTask{
+		pid:       handle.PID,
+		startedAt: startedAt,
+		doneCh:    make(chan struct{}),
+		exit:      &ExitResult{Err: ErrExitUnknown},
+	}
+	go watchProcess(handle.PID, handle.StartTime, t.doneCh)
+
+	d.tasksMu.Lock()
+	d.tasks[id] = t
+	d.tasksMu.Unlock()
+	return nil
+}
+
+func (d *RawExec) WaitTask(ctx context.Context, id string) (<-chan *ExitResult, error) {
+	t, err := d.task(id)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan *ExitResult, 1)
+	go func() {
+		select {
+		case <-t.doneCh:
+			ch <- t.exit
+		case <-ctx.Done():
+		}
+	}()
+	return ch, nil
+}
+
+// StopTask signals the task's process group and kills it if the task does not
+// exit within timeout.
+func (d *RawExec) StopTask(id string, timeout time.Duration, signal string) error {
+	t, err := d.task(id)
+	if err != nil {
+		return err
+	}
+	sig, err := ParseSignal(signal)
+	if err != nil {
+		return err
+	}
+
+	if err := syscall.Kill(-t.pid, sig); err != nil {
+		d.log.Warn("error sending kill signal", "task_id", id, "error", err)
+	}
+	if sig == syscall.SIGKILL {
+		<-t.doneCh
+		return nil
+	}
+
+	select {
+	case <-t.doneCh:
+		return nil
+	case <-time.After(timeout):
+	}
+
+	d.log.Warn("task did not exit after kill timeout; force killing", "task_id", id, "timeout", timeout)
+	if err := syscall.Kill(-t.pid, syscall.SIGKILL); err != nil {
+		d.log.Warn("error force killing", "task_id", id, "error", err)
+	}
+	<-t.doneCh
+	return nil
+}
+
+func (d *RawExec) DestroyTask(id string) {
+	d.tasksMu.Lock()
+	defer d.tasksMu.Unlock()
+	delete(d.tasks, id)
+}
+
+// SignalTask signals the task's process but not the rest of its group.
+func (d *RawExec) SignalTask(id string, signal string) error {
+	t, err := d.task(id)
+	if err != nil {
+		return err
+	}
+	sig, err := ParseSignal(signal)
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(t.pid, sig)
+}
+
+func (d *RawExec) InspectTask(id string) (*TaskStatus, error) {
+	t, err := d.task(id)
+	if err != nil {
+		return nil, err
+	}
+	status := &TaskStatus{
+		ID:        id,
+		State:     TaskStateRunning,
+		StartedAt: t.startedAt,
+	}
+	select {
+	case <-t.doneCh:
+		status.State = TaskStateExited
+		status.ExitResult = t.exit
+	default:
+	}
+	return status, nil
+}
+
+// TaskStats returns the resource usage of the task's process. Processes it
+// started are not included.
+func (d *RawExec) TaskStats(id string) (*TaskResourceUsage, error) {
+	t, err := d.task(id)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := processStat(t.pid)
+	if err != nil {
+		return nil, err
+	}
+
+	// fields[11] and fields[12] are utime and stime (fields 14 and 15) and
+	// fields[21] is rss in pages (field 24)
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rss, _ := strconv.ParseUint(fields[21], 10, 64)
+	return &TaskResourceUsage{
+		RSSBytes:   rss * uint64(os.Getpagesize()),
+		UserTime:   time.Duration(utime) * time.Second / clockTicks,
+		SystemTime: time.Duration(stime) * time.Second / clockTicks,
+		Timestamp:  time.Now(),
+	}, nil
+}
+
+// task returns the task with the ID.
+func (d *RawExec) task(id string) (*rawExecTask, error) {
+	d.tasksMu.Lock()
+	defer d.tasksMu.Unlock()
+	t, ok := d.tasks[id]
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	return t, nil
+}
+
+// exitResult converts a command's Wait error into its exit result.
+func exitResult(err error) *ExitResult {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return &ExitResult{}
+	case errors.As(err, &exitErr):
+		res := &ExitResult{ExitCode: exitErr.ExitCode()}
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			res.Signal = int(status.Signal())
+		}
+		return res
+	default:
+		return &ExitResult{ExitCode: -1, Err: err}
+	}
+}
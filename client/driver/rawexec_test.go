@@ -0,0 +1,226 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestRawExec returns an enabled raw_exec driver.
+func newTestRawExec(t *testing.T) *RawExec {
+	t.Helper()
+	d, err := NewRawExec(slog.New(slog.DiscardHandler), nil)
+	if err != nil {
+		t.Fatalf("error creating driver: %v", err)
+	}
+	return d
+}
+
+// testTaskConfig returns a task running command with args that logs to a
+// temporary directory.
+func testTaskConfig(t *testing.T, id, command string, args ...any) *TaskConfig {
+	t.Helper()
+	dir := t.TempDir()
+	return &TaskConfig{
+		ID:         id,
+		Config:     map[string]any{"command": command, "args": args},
+		StdoutPath: filepath.Join(dir, "stdout"),
+		StderrPath: filepath.Join(dir, "stderr"),
+	}
+}
+
+// wait returns the task's exit result.
+func wait(t *testing.T, d Driver, id string) *ExitResult {
+	t.Helper()
+	ch, err := d.WaitTask(context.Background(), id)
+	if err != nil {
+		t.Fatalf("error waiting on task: %v", err)
+	}
+	select {
+	case res := <-ch:
+		return res
+	case <-time.After(10 * time.Second):
+		t.Fatalf("task did not exit")
+		return nil
+	}
+}
+
+func TestRawExec_ExitResult(t *testing.T) {
+	d := newTestRawExec(t)
+
+	if _, err := d.StartTask(testTaskConfig(t, "ok", "sh", "-c", "exit 0")); err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	if res := wait(t, d, "ok"); !res.Successful() {
+		t.Errorf("expected success, got %+v", res)
+	}
+
+	if _, err := d.StartTask(testTaskConfig(t, "fail", "sh", "-c", "exit 3")); err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	if res := wait(t, d, "fail"); res.ExitCode != 3 || res.Successful() {
+		t.Errorf("expected exit code 3, got %+v", res)
+	}
+
+	if _, err := d.StartTask(testTaskConfig(t, "killed", "sh", "-c", "kill -TERM $$")); err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	if res := wait(t, d, "killed"); res.Signal != int(syscall.SIGTERM) {
+		t.Errorf("expected SIGTERM, got %+v", res)
+	}
+
+	status, err := d.InspectTask("killed")
+	if err != nil {
+		t.Fatalf("error inspecting task: %v", err)
+	}
+	if status.State != TaskStateExited || status.ExitResult == nil || status.StartedAt.IsZero() {
+		t.Errorf("expected exited task with start time, got %+v", status)
+	}
+
+	d.DestroyTask("killed")
+	if _, err := d.InspectTask("killed"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected destroyed task to be not found, got %v", err)
+	}
+}
+
+func TestRawExec_Argv0(t *testing.T) {
+	d := newTestRawExec(t)
+	config := testTaskConfig(t, "argv", "sh", "-c", `echo "$0"`)
+	if _, err := d.StartTask(config); err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	wait(t, d, "argv")
+
+	out, err := os.ReadFile(config.StdoutPath)
+	if err != nil {
+		t.Fatalf("error reading stdout: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "sh" {
+		t.Errorf("expected argv[0] of sh, got %q", got)
+	}
+}
+
+func TestRawExec_StartErrors(t *testing.T) {
+	d := newTestRawExec(t)
+	cases := []struct {
+		config map[string]any
+		err    string
+	}{
+		{map[string]any{}, "missing command"},
+		{map[string]any{"command": "nomadlet-missing-command"}, "error finding command"},
+		{map[string]any{"command": "true", "args": "-v"}, "invalid type for args"},
+		{map[string]any{"command": "true", "args": []any{1}}, "invalid type for arg element 0"},
+	}
+	for _, tc := range cases {
+		config := testTaskConfig(t, "t", "")
+		config.Config = tc.config
+		_, err := d.StartTask(config)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expected error %q, got %v", tc.config, tc.err, err)
+		}
+	}
+}
+
+func TestRawExec_StopTask(t *testing.T) {
+	d := newTestRawExec(t)
+
+	// The stop signal is sent to the task's process group
+	if _, err := d.StartTask(testTaskConfig(t, "graceful", "sleep", "30")); err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	if err := d.StopTask("graceful", 5*time.Second, "SIGINT"); err != nil {
+		t.Fatalf("error stopping task: %v", err)
+	}
+	if res := wait(t, d, "graceful"); res.Signal != int(syscall.SIGINT) {
+		t.Errorf("expected SIGINT, got %+v", res)
+	}
+
+	// Tasks ignoring the signal are killed after the timeout
+	if _, err := d.StartTask(testTaskConfig(t, "stubborn", "sh", "-c", `trap "" INT; sleep 30`)); err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	// Allow the shell to install its trap
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if err := d.StopTask("stubborn", 200*time.Millisecond, "SIGINT"); err != nil {
+		t.Fatalf("error stopping task: %v", err)
+	}
+	if res := wait(t, d, "stubborn"); res.Signal != int(syscall.SIGKILL) {
+		t.Errorf("expected SIGKILL, got %+v", res)
+	}
+	if took := time.Since(start); took < 200*time.Millisecond {
+		t.Errorf("expected task to be killed after timeout, took %s", took)
+	}
+
+	if err := d.StopTask("missing", time.Second, "SIGINT"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if err := d.SignalTask("stubborn", "SIGBOGUS"); err == nil {
+		t.Errorf("expected invalid signal to be rejected")
+	}
+}
+
+func TestRawExec_RecoverTask(t *testing.T) {
+	d := newTestRawExec(t)
+	handle, err := d.StartTask(testTaskConfig(t, "t", "sleep", "30"))
+	if err != nil {
+		t.Fatalf("error starting task: %v", err)
+	}
+	started, err := d.InspectTask("t")
+	if err != nil {
+		t.Fatalf("error inspecting task: %v", err)
+	}
+
+	// A new driver, as after nomadlet restarts, reattaches to the process
+	recovered := newTestRawExec(t)
+	if err := recovered.RecoverTask("t", handle); err != nil {
+		t.Fatalf("error recovering task: %v", err)
+	}
+	status, err := recovered.InspectTask("t")
+	if err != nil {
+		t.Fatalf("error inspecting task: %v", err)
+	}
+	if status.State != TaskStateRunning {
+		t.Errorf("expected recovered task to be running, got %q", status.State)
+	}
+	// The start time from /proc is only precise to the second
+	if diff := status.StartedAt.Sub(started.StartedAt).Abs(); diff > 2*time.Second {
+		t.Errorf("expected start time near %s, got %s", started.StartedAt, status.StartedAt)
+	}
+
+	// A process has no memory of its own until it execs
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		usage, err := recovered.TaskStats("t")
+		if err != nil {
+			t.Fatalf("error getting stats: %v", err)
+		}
+		if usage.RSSBytes > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected rss to be measured")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The exit status of recovered tasks is unknown
+	if err := recovered.StopTask("t", time.Second, "SIGKILL"); err != nil {
+		t.Fatalf("error stopping task: %v", err)
+	}
+	if res := wait(t, recovered, "t"); !errors.Is(res.Err, ErrExitUnknown) {
+		t.Errorf("expected unknown exit, got %+v", res)
+	}
+	wait(t, d, "t")
+
+	// Exited processes cannot be recovered
+	if err := newTestRawExec(t).RecoverTask("t", handle); err == nil {
+		t.Errorf("expected exited task to not be recovered")
+	}
+}
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGABRT":  syscall.SIGABRT,
+	"SIGALRM":  syscall.SIGALRM,
+	"SIGBUS":   syscall.SIGBUS,
+	"SIGCONT":  syscall.SIGCONT,
+	"SIGFPE":   syscall.SIGFPE,
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGILL":   syscall.SIGILL,
+	"SIGINT":   syscall.SIGINT,
+	"SIGIO":    syscall.SIGIO,
+	"SIGIOT":   syscall.SIGIOT,
+	"SIGKILL":  syscall.SIGKILL,
+	"SIGPIPE":  syscall.SIGPIPE,
+	"SIGPROF":  syscall.SIGPROF,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGSEGV":  syscall.SIGSEGV,
+	"SIGSTOP":  syscall.SIGSTOP,
+	"SIGSYS":   syscall.SIGSYS,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGTRAP":  syscall.SIGTRAP,
+	"SIGTSTP":  syscall.SIGTSTP,
+	"SIGTTIN":  syscall.SIGTTIN,
+	"SIGTTOU":  syscall.SIGTTOU,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGWINCH": syscall.SIGWINCH,
+	"SIGXCPU":  syscall.SIGXCPU,
+	"SIGXFSZ":  syscall.SIGXFSZ,
+}
+
+// ParseSignal converts a signal name such as SIGHUP or HUP into a signal.
+// Defaults to SIGKILL like Nomad if name is empty.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return syscall.SIGKILL, nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,322 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/allocrunner/taskrunner"
+	"github.com/schmichael/nomadlet/client/driver"
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/ugorji/go/codec"
+)
+
+var (
+	msgpackHandle = rpc.NewMsgpackHandle()
+
+	// Status codes sent with streaming RPC errors
+	errCodeBadRequest = int64(400)
+	errCodeNotFound   = int64(404)
+	errCodeInternal   = int64(500)
+)
+
+// registerEndpoints registers the RPCs servers may call on this client.
+func (c *Client) registerEndpoints(srv *rpc.Server) error {
+	if err := srv.Register("ClientStatus", &clientStatusEndpoint{c: c}); err != nil {
+		return err
+	}
+	if err := srv.Register("ClientAllocations", &allocationsEndpoint{c: c}); err != nil {
+		return err
+	}
+	if err := srv.Register("NodeMeta", &nodeMetaEndpoint{c: c}); err != nil {
+		return err
+	}
+	if err := srv.Register("HostVolume", &hostVolumeEndpoint{c: c}); err != nil {
+		return err
+	}
+	srv.RegisterStreaming("ClientAllocations.Exec", c.allocExec)
+	srv.RegisterStreaming("FileSystem.Logs", c.fsLogs)
+	return nil
+}
+
+type clientStatusEndpoint struct {
+	c *Client
+}
+
+// Ping is used by servers to check the client is reachable.
+func (e *clientStatusEndpoint) Ping(args *struct{}, reply *struct{}) error {
+	return nil
+}
+
+type allocationsEndpoint struct {
+	c *Client
+}
+
+// lookupTasks returns the named task or all tasks if name is empty.
+func (e *allocationsEndpoint) lookupTasks(allocID, name string) ([]*taskrunner.TaskRunner, error) {
+	ar, err := e.c.getAllocRunner(allocID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return ar.Tasks(), nil
+	}
+	tr := ar.Task(name)
+	if tr == nil {
+		return nil, fmt.Errorf("Failed to find task %q in allocation %q", name, allocID)
+	}
+	return []*taskrunner.TaskRunner{tr}, nil
+}
+
+// Signal sends a signal to one or all of an allocation's running tasks.
+func (e *allocationsEndpoint) Signal(args *rpc.AllocSignalRequest, reply *rpc.GenericResponse) error {
+	tasks, err := e.lookupTasks(args.AllocID, args.Task)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tr := range tasks {
+		err := tr.Signal(args.Signal)
+		if errors.Is(err, taskrunner.ErrTaskNotRunning) && args.Task == "" {
+			// Only signal running tasks when signalling all tasks
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error signalling task %q: %w", tr.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Restart restarts one or all of an allocation's running tasks.
+func (e *allocationsEndpoint) Restart(args *rpc.AllocRestartRequest, reply *rpc.GenericResponse) error {
+	name := args.TaskName
+	if args.AllTasks {
+		name = ""
+	}
+	tasks, err := e.lookupTasks(args.AllocID, name)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tr := range tasks {
+		err := tr.Restart()
+		if errors.Is(err, taskrunner.ErrTaskNotRunning) && name == "" {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error restarting task %q: %w", tr.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the resource usage of one or all of an allocation's running
+// tasks. CPU usage is averaged over the time each task has been running.
+func (e *allocationsEndpoint) Stats(args *rpc.AllocStatsRequest, reply *rpc.AllocStatsResponse) error {
+	tasks, err := e.lookupTasks(args.AllocID, args.Task)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stats := &rpc.AllocResourceUsage{
+		ResourceUsage: &rpc.ResourceUsage{
+			MemoryStats: &rpc.MemoryStats{},
+			CpuStats:    &rpc.CpuStats{},
+		},
+		Tasks:     map[string]*rpc.TaskResourceUsage{},
+		Timestamp: now.UnixNano(),
+	}
+	for _, tr := range tasks {
+		usage, startedAt, err := tr.Stats()
+		if errors.Is(err, taskrunner.ErrTaskNotRunning) && args.Task == "" {
+			// Only running tasks are included when listing all tasks
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error getting stats of task %q: %w", tr.Name(), err)
+		}
+
+		ru := resourceUsage(usage, startedAt)
+		stats.Tasks[tr.Name()] = &rpc.TaskResourceUsage{
+			ResourceUsage: ru,
+			Timestamp:     usage.Timestamp.UnixNano(),
+		}
+
+		total := stats.ResourceUsage
+		total.MemoryStats.RSS += ru.MemoryStats.RSS
+		total.MemoryStats.Measured = ru.MemoryStats.Measured
+		total.CpuStats.SystemMode += ru.CpuStats.SystemMode
+		total.CpuStats.UserMode += ru.CpuStats.UserMode
+		total.CpuStats.Percent += ru.CpuStats.Percent
+		total.CpuStats.Measured = ru.CpuStats.Measured
+	}
+	reply.Stats = stats
+	return nil
+}
+
+// resourceUsage converts a task's usage to its RPC form. CPU percentages are
+// only measured if the task's start time is known.
+func resourceUsage(usage *driver.TaskResourceUsage, startedAt time.Time) *rpc.ResourceUsage {
+	ru := &rpc.ResourceUsage{
+		MemoryStats: &rpc.MemoryStats{
+			RSS:      usage.RSSBytes,
+			Measured: []string{"RSS"},
+		},
+		CpuStats: &rpc.CpuStats{},
+	}
+	elapsed := usage.Timestamp.Sub(startedAt)
+	if startedAt.IsZero() || elapsed <= 0 {
+		return ru
+	}
+	percent := func(d time.Duration) float64 {
+		return float64(d) / float64(elapsed) * 100
+	}
+	ru.CpuStats.UserMode = percent(usage.UserTime)
+	ru.CpuStats.SystemMode = percent(usage.SystemTime)
+	ru.CpuStats.Percent = percent(usage.UserTime + usage.SystemTime)
+	ru.CpuStats.Measured = []string{"System Mode", "User Mode", "Percent"}
+	return ru
+}
+
+// sendStreamErr sends an error to the caller of a streaming RPC.
+func sendStreamErr(enc *codec.Encoder, err error, code int64) {
+	enc.Encode(&rpc.StreamErrWrapper{Error: rpc.NewRpcError(err, &code)})
+}
+
+// sendStreamPayload JSON encodes v and sends it as the payload of a
+// StreamErrWrapper.
+func sendStreamPayload(enc *codec.Encoder, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(&rpc.StreamErrWrapper{Payload: buf})
+}
+
+// allocExec runs a command in a task's environment, streaming stdin from and
+// stdout/stderr to the caller.
+func (c *Client) allocExec(conn io.ReadWriteCloser) {
+	dec := codec.NewDecoder(conn, msgpackHandle)
+	enc := codec.NewEncoder(conn, msgpackHandle)
+
+	req := &rpc.AllocExecRequest{}
+	if err := dec.Decode(req); err != nil {
+		sendStreamErr(enc, fmt.Errorf("error decoding request: %w", err), errCodeBadRequest)
+		return
+	}
+	if req.Tty {
+		sendStreamErr(enc, errors.New("tty is not supported; retry with -t=false"), errCodeBadRequest)
+		return
+	}
+	if req.Task == "" {
+		sendStreamErr(enc, errors.New("missing task name"), errCodeBadRequest)
+		return
+	}
+
+	ar, err := c.getAllocRunner(req.AllocID)
+	if err != nil {
+		sendStreamErr(enc, err, errCodeNotFound)
+		return
+	}
+	tr := ar.Task(req.Task)
+	if tr == nil {
+		sendStreamErr(enc, fmt.Errorf("unknown task name %q", req.Task), errCodeNotFound)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cmd, err := tr.ExecCommand(ctx, req.Cmd)
+	if err != nil {
+		sendStreamErr(enc, err, errCodeBadRequest)
+		return
+	}
+
+	// Output frames are written from multiple goroutines
+	var encMu sync.Mutex
+	send := func(out *rpc.ExecStreamingOutput) error {
+		encMu.Lock()
+		defer encMu.Unlock()
+		return sendStreamPayload(enc, out)
+	}
+	cmd.Stdout = &execWriter{send: send, stderr: false}
+	cmd.Stderr = &execWriter{send: send, stderr: true}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		sendStreamErr(enc, err, errCodeInternal)
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		sendStreamErr(enc, fmt.Errorf("error starting command: %w", err), errCodeInternal)
+		return
+	}
+
+	// Forward stdin until the caller closes it or disconnects
+	go func() {
+		for {
+			input := &rpc.ExecStreamingInput{}
+			if err := dec.Decode(input); err != nil {
+				// Caller went away
+				cancel()
+				return
+			}
+			if input.Stdin == nil {
+				continue
+			}
+			if len(input.Stdin.Data) > 0 {
+				stdin.Write(input.Stdin.Data)
+			}
+			if input.Stdin.Close {
+				stdin.Close()
+			}
+		}
+	}()
+
+	exitCode := 0
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			sendStreamErr(enc, err, errCodeInternal)
+			return
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	send(&rpc.ExecStreamingOutput{
+		Exited: true,
+		Result: &rpc.ExecStreamingExitResult{ExitCode: exitCode},
+	})
+}
+
+// execWriter sends everything written to it as exec output frames.
+type execWriter struct {
+	send   func(*rpc.ExecStreamingOutput) error
+	stderr bool
+}
+
+func (w *execWriter) Write(p []byte) (int, error) {
+	op := &rpc.ExecStreamingIOOperation{Data: bytes.Clone(p)}
+	out := &rpc.ExecStreamingOutput{}
+	if w.stderr {
+		out.Stderr = op
+	} else {
+		out.Stdout = op
+	}
+	if err := w.send(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
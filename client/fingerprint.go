@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/fingerprint"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// nodeUpdateBatchInterval is how long node changes are batched before
+	// re-registering the node, so a flapping fingerprint re-registers at most
+	// once per interval.
+	nodeUpdateBatchInterval = 5 * time.Second
+
+	// driverFingerprintInterval is how often drivers' health is refreshed.
+	driverFingerprintInterval = 30 * time.Second
+
+	// driverAttributePrefix prefixes the attributes drivers report.
+	driverAttributePrefix = "driver."
+)
+
+// fingerprinters returns the fingerprinters that build the node, including
+// one reporting the health of the client's drivers.
+func (c *Client) fingerprinters() []fingerprint.Fingerprinter {
+	return append(slices.Clone(fingerprint.Fingerprinters), fingerprint.Fingerprinter{
+		Name:        "drivers",
+		Interval:    driverFingerprintInterval,
+		Fingerprint: c.fingerprintDrivers,
+	})
+}
+
+// fingerprintDrivers sets the node's drivers and the attributes of those that
+// are detected.
+func (c *Client) fingerprintDrivers(config *structs.Config, node *structs.Node) error {
+	node.Drivers = c.drivers.Fingerprint()
+
+	maps.DeleteFunc(node.Attributes, func(k, _ string) bool {
+		return strings.HasPrefix(k, driverAttributePrefix)
+	})
+	for _, info := range node.Drivers {
+		if info.Detected {
+			maps.Copy(node.Attributes, info.Attributes)
+		}
+	}
+	return nil
+}
+
+// fingerprint refreshes each fingerprint at its interval until ctx is done.
+func (c *Client) fingerprint(ctx context.Context) {
+	defer c.log.Debug("fingerprinters exited")
+
+	var wg sync.WaitGroup
+	for _, f := range c.fingerprinters() {
+		if f.Interval <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.runFingerprinter(ctx, f)
+		}()
+	}
+	wg.Wait()
+}
+
+// runFingerprinter refreshes a fingerprint at its interval until ctx is done.
+func (c *Client) runFingerprinter(ctx context.Context, f fingerprint.Fingerprinter) {
+	ticker := time.NewTicker(f.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := c.refreshFingerprint(f)
+		switch {
+		case err != nil:
+			c.log.Warn("error refreshing fingerprint", "fingerprinter", f.Name, "error", err)
+		case changed:
+			c.log.Info("fingerprint changed node", "fingerprinter", f.Name)
+		}
+	}
+}
+
+// refreshFingerprint runs a fingerprint on a copy of the node and updates the
+// node if it changed. The previous results are kept if fingerprinting fails.
+// Returns true if the node changed.
+func (c *Client) refreshFingerprint(f fingerprint.Fingerprinter) (bool, error) {
+	// Hold stateMu so reloading or applying dynamic meta does not replace
+	// the node concurrently
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	node := c.getNode().Copy()
+	if err := f.Fingerprint(c.Config(), node); err != nil {
+		return false, err
+	}
+	return c.setNode(node), nil
+}
+
+// watchNodeUpdates re-registers the node after it changes. Changes are
+// batched for nodeUpdateBatchInterval.
+func (c *Client) watchNodeUpdates(ctx context.Context) {
+	defer c.log.Debug("node update watcher exited")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.nodeUpdateCh:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(nodeUpdateBatchInterval):
+		}
+
+		// Changes made while batching are included
+		select {
+		case <-c.nodeUpdateCh:
+		default:
+		}
+		select {
+		case c.registerCh <- struct{}{}:
+		default:
+		}
+	}
+}
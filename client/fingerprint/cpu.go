@@ -0,0 +1,273 @@
+package fingerprint
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// cpuSysPath contains a cpuN directory per logical CPU.
+	cpuSysPath = "/sys/devices/system/cpu"
+
+	// defaultCPUSpeed is the speed in MHz guessed for cores whose speed is
+	// not exposed, such as on many arm64 VMs.
+	defaultCPUSpeed = 1000
+)
+
+var (
+	// guessSpeeds are each processor's "cpu MHz" from the first read of
+	// /proc/cpuinfo. The current speed varies with frequency scaling, so it is
+	// only read once to keep the node's resources stable.
+	guessSpeeds   map[int]uint64
+	guessSpeedsMu sync.Mutex
+)
+
+// cpuInfo is a processor entry in /proc/cpuinfo.
+type cpuInfo struct {
+	model      string
+	mhz        uint64
+	physicalID int
+}
+
+// CPU sets the node's processor topology and cpu attributes. Cores outside
+// nomadlet's cpuset, such as when limited by a cgroup, are disabled.
+// config.Cores limits the number of usable cores and config.Mhz overrides the
+// total compute.
+func CPU(config *structs.Config, node *structs.Node) error {
+	infos, err := readCPUInfo()
+	if err != nil {
+		return err
+	}
+
+	ids, err := readCPUList(filepath.Join(cpuSysPath, "online"))
+	if err != nil {
+		// Assume all processors listed by the kernel are online
+		ids = nil
+		for id := range infos {
+			ids = append(ids, id)
+		}
+		slices.Sort(ids)
+	}
+
+	allowed, err := allowedCPUs()
+	if err != nil {
+		return err
+	}
+
+	topology := structs.Topology{
+		OverrideTotalCompute: uint64(max(config.Mhz, 0)),
+	}
+	model := ""
+	usable := 0
+	for _, id := range ids {
+		core := structs.Core{
+			ID:       uint16(id),
+			SocketID: uint8(infos[id].physicalID),
+			Disable:  !slices.Contains(allowed, id),
+		}
+		dir := filepath.Join(cpuSysPath, fmt.Sprintf("cpu%d", id))
+		if v, err := readUint(filepath.Join(dir, "topology", "physical_package_id")); err == nil {
+			core.SocketID = uint8(v)
+		}
+		if nodes, _ := filepath.Glob(filepath.Join(dir, "node[0-9]*")); len(nodes) > 0 {
+			if v, err := strconv.ParseUint(strings.TrimPrefix(filepath.Base(nodes[0]), "node"), 10, 8); err == nil {
+				core.NodeID = uint8(v)
+			}
+		}
+
+		// cpufreq is in kHz
+		if v, err := readUint(filepath.Join(dir, "cpufreq", "cpuinfo_max_freq")); err == nil {
+			core.MaxSpeed = v / 1000
+		}
+		if v, err := readUint(filepath.Join(dir, "cpufreq", "base_frequency")); err == nil {
+			core.BaseSpeed = v / 1000
+		}
+		core.GuessSpeed = guessSpeed(id, infos[id])
+
+		if !core.Disable {
+			if config.Cores > 0 && usable >= config.Cores {
+				core.Disable = true
+			} else {
+				usable++
+			}
+		}
+		if model == "" {
+			model = infos[id].model
+		}
+		topology.Cores = append(topology.Cores, core)
+	}
+	if len(topology.Cores) == 0 {
+		return fmt.Errorf("no online cpus found")
+	}
+
+	setCPU(node, topology, model)
+	return nil
+}
+
+// CPUFallback sets the node's processor topology from config when detecting
+// it fails. Each core is assumed to run at the default speed unless config
+// sets the total compute.
+func CPUFallback(config *structs.Config, node *structs.Node) {
+	n := config.Cores
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	topology := structs.Topology{
+		OverrideTotalCompute: uint64(max(config.Mhz, 0)),
+	}
+	for id := range n {
+		topology.Cores = append(topology.Cores, structs.Core{
+			ID:         uint16(id),
+			GuessSpeed: defaultCPUSpeed,
+		})
+	}
+	setCPU(node, topology, "")
+}
+
+// guessSpeed returns the speed of a processor whose frequency is not exposed by
+// cpufreq. The nominal speed in the model name, such as "@ 2.40GHz", is
+// preferred over the speed first read from cpuinfo.
+func guessSpeed(id int, info cpuInfo) uint64 {
+	if _, v, ok := strings.Cut(info.model, "@"); ok {
+		if ghz, ok := strings.CutSuffix(strings.TrimSpace(v), "GHz"); ok {
+			if f, err := strconv.ParseFloat(ghz, 64); err == nil && f > 0 {
+				return uint64(math.Round(f * 1000))
+			}
+		}
+	}
+
+	guessSpeedsMu.Lock()
+	defer guessSpeedsMu.Unlock()
+	if guessSpeeds == nil {
+		guessSpeeds = map[int]uint64{}
+	}
+	mhz, ok := guessSpeeds[id]
+	if !ok {
+		mhz = info.mhz
+		if mhz == 0 {
+			mhz = defaultCPUSpeed
+		}
+		guessSpeeds[id] = mhz
+	}
+	return mhz
+}
+
+// setCPU sets the node's topology and the attributes derived from it.
+func setCPU(node *structs.Node, topology structs.Topology, model string) {
+	node.NodeResources.Processors.Topology = topology
+
+	var freq uint64
+	for _, c := range topology.Cores {
+		freq = max(freq, c.MHz())
+	}
+
+	node.Attributes["cpu.numcores"] = strconv.Itoa(topology.UsableCores())
+	node.Attributes["cpu.reservablecores"] = strconv.Itoa(topology.UsableCores())
+	node.Attributes["cpu.frequency"] = strconv.FormatUint(freq, 10)
+	node.Attributes["cpu.totalcompute"] = strconv.FormatUint(topology.TotalCompute(), 10)
+	node.Attributes["cpu.usablecompute"] = strconv.FormatUint(topology.UsableCompute(), 10)
+	if model != "" {
+		node.Attributes["cpu.modelname"] = model
+	}
+}
+
+// readCPUInfo parses /proc/cpuinfo into its processors keyed by ID.
+func readCPUInfo() (map[int]cpuInfo, error) {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return nil, fmt.Errorf("error reading cpuinfo: %w", err)
+	}
+	defer f.Close()
+
+	infos := map[int]cpuInfo{}
+	id := -1
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if k == "processor" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpuinfo processor %q", v)
+			}
+			id = n
+			infos[id] = cpuInfo{}
+			continue
+		}
+		if id < 0 {
+			continue
+		}
+
+		info := infos[id]
+		switch k {
+		case "model name":
+			info.model = v
+		case "cpu MHz":
+			if mhz, err := strconv.ParseFloat(v, 64); err == nil {
+				info.mhz = uint64(mhz)
+			}
+		case "physical id":
+			info.physicalID, _ = strconv.Atoi(v)
+		}
+		infos[id] = info
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading cpuinfo: %w", err)
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("no processors found in cpuinfo")
+	}
+	return infos, nil
+}
+
+// allowedCPUs returns the CPUs nomadlet may run on. The kernel intersects the
+// cgroup cpuset and the scheduler affinity.
+func allowedCPUs() ([]int, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return nil, fmt.Errorf("error reading allowed cpus: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if v, ok := strings.CutPrefix(scanner.Text(), "Cpus_allowed_list:"); ok {
+			return structs.ParseCPUList(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading allowed cpus: %w", err)
+	}
+	return nil, fmt.Errorf("allowed cpus not found in /proc/self/status")
+}
+
+// readCPUList reads a file containing a cpu list.
+func readCPUList(path string) ([]int, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return structs.ParseCPUList(string(buf))
+}
+
+// readUint reads a file containing an unsigned integer.
+func readUint(path string) (uint64, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(buf)), 10, 64)
+}
@@ -0,0 +1,25 @@
+package fingerprint
+
+import "testing"
+
+func TestGuessSpeed(t *testing.T) {
+	// The model's nominal speed is used when present
+	model := cpuInfo{model: "Intel(R) Xeon(R) CPU E5-2680 v4 @ 2.40GHz", mhz: 1197}
+	if mhz := guessSpeed(0, model); mhz != 2400 {
+		t.Errorf("expected 2400 from model name, got %d", mhz)
+	}
+
+	// Otherwise the first speed read is kept as the current speed changes
+	info := cpuInfo{model: "AMD EPYC 7B13", mhz: 2450}
+	if mhz := guessSpeed(1, info); mhz != 2450 {
+		t.Errorf("expected 2450, got %d", mhz)
+	}
+	info.mhz = 3100
+	if mhz := guessSpeed(1, info); mhz != 2450 {
+		t.Errorf("expected speed to remain 2450, got %d", mhz)
+	}
+
+	if mhz := guessSpeed(2, cpuInfo{}); mhz != defaultCPUSpeed {
+		t.Errorf("expected default speed, got %d", mhz)
+	}
+}
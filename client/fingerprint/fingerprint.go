@@ -0,0 +1,56 @@
+// Package fingerprint detects the node's resources and attributes.
+package fingerprint
+
+import (
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// Fingerprinter detects some of the node's resources and attributes.
+// Fingerprint may modify any of the node's fields as it is run on a deep copy
+// of the registered node.
+type Fingerprinter struct {
+	Name string
+
+	// Interval is how often the fingerprint is refreshed after the node is
+	// built. 0 only fingerprints when the node is built, such as when
+	// starting or reloading.
+	Interval time.Duration
+
+	Fingerprint func(config *structs.Config, node *structs.Node) error
+
+	// Fallback sets the fields from config when Fingerprint fails building
+	// the node. May be nil.
+	Fallback func(config *structs.Config, node *structs.Node)
+}
+
+// Fingerprinters are run in order to build the node.
+var Fingerprinters = []Fingerprinter{
+	{
+		Name:        "cpu",
+		Interval:    5 * time.Minute,
+		Fingerprint: CPU,
+		Fallback:    CPUFallback,
+	},
+	{
+		Name:        "memory",
+		Interval:    time.Minute,
+		Fingerprint: Memory,
+	},
+	{
+		Name:        "storage",
+		Fingerprint: Storage,
+	},
+	{
+		Name:        "network",
+		Interval:    30 * time.Second,
+		Fingerprint: Network,
+	},
+	{
+		// Plugins are only refreshed when the node is rebuilt, such as when
+		// reloading
+		Name:        "host_volume_plugins",
+		Fingerprint: HostVolumePlugins,
+	},
+}
@@ -0,0 +1,28 @@
+package fingerprint
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/schmichael/nomadlet/client/hostvolume"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// hostVolumePluginPrefix prefixes the attributes servers check for a plugin
+// before creating dynamic host volumes on the node.
+const hostVolumePluginPrefix = "plugins.host_volume."
+
+// HostVolumePlugins sets a version attribute for each dynamic host volume
+// plugin in config.HostVolumePluginDir. Plugins that fail to fingerprint are
+// omitted and returned as errors.
+func HostVolumePlugins(config *structs.Config, node *structs.Node) error {
+	versions, err := hostvolume.Fingerprint(config.HostVolumePluginDir)
+
+	maps.DeleteFunc(node.Attributes, func(k, _ string) bool {
+		return strings.HasPrefix(k, hostVolumePluginPrefix)
+	})
+	for id, version := range versions {
+		node.Attributes[hostVolumePluginPrefix+id+".version"] = version
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package fingerprint
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// cgroupPath is where cgroup filesystems are mounted.
+	cgroupPath = "/sys/fs/cgroup"
+
+	bytesPerMB = 1024 * 1024
+)
+
+// Memory sets the node's total memory and memory attributes to the system's
+// memory or nomadlet's cgroup memory limit if lower. config.Mem overrides the
+// detected memory.
+func Memory(config *structs.Config, node *structs.Node) error {
+	total := uint64(config.Mem) * bytesPerMB
+	if total == 0 {
+		var err error
+		if total, err = memTotal(); err != nil {
+			return err
+		}
+		if limit, ok := cgroupMemoryLimit(); ok && limit < total {
+			total = limit
+		}
+	}
+
+	node.NodeResources.Memory.MemoryMB = int64(total / bytesPerMB)
+	node.Attributes["memory.totalbytes"] = strconv.FormatUint(total, 10)
+	return nil
+}
+
+// memTotal returns the system's memory in bytes from /proc/meminfo.
+func memTotal() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("error reading meminfo: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		v, ok := strings.CutPrefix(scanner.Text(), "MemTotal:")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "kB")), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal %q", v)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("error reading meminfo: %w", err)
+	}
+	return 0, fmt.Errorf("MemTotal not found in meminfo")
+}
+
+// cgroupMemoryLimit returns nomadlet's cgroup memory limit in bytes or false
+// if it is unlimited or unknown. Both cgroup v2 and the v1 memory controller
+// are supported.
+func cgroupMemoryLimit() (uint64, bool) {
+	buf, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+
+	var paths []string
+	for _, line := range strings.Split(string(buf), "\n") {
+		// Lines are hierarchy-ID:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			paths = append(paths,
+				filepath.Join(cgroupPath, parts[2], "memory.max"),
+				filepath.Join(cgroupPath, "unified", parts[2], "memory.max"))
+		case strings.Contains(","+parts[1]+",", ",memory,"):
+			// The cgroup's path may not be visible inside a container, in
+			// which case its limit is at the root of the mount
+			paths = append(paths,
+				filepath.Join(cgroupPath, "memory", parts[2], "memory.limit_in_bytes"),
+				filepath.Join(cgroupPath, "memory", "memory.limit_in_bytes"))
+		}
+	}
+
+	var limit uint64
+	for _, path := range paths {
+		// Unlimited is "max" in v2 and a page aligned max int64 in v1, which
+		// is larger than any system's memory
+		v, err := readUint(path)
+		if err != nil || v == 0 {
+			continue
+		}
+		if limit == 0 || v < limit {
+			limit = v
+		}
+	}
+	return limit, limit > 0
+}
@@ -0,0 +1,180 @@
+package fingerprint
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// netSysPath contains a directory per network interface.
+const netSysPath = "/sys/class/net"
+
+// Network sets the node's networks to the addresses of its interfaces. The
+// default interface's addresses are in the default host network and any
+// addresses matching a configured host network are also in that network.
+// config.NetworkInterface overrides the interface of the default route as the
+// default interface.
+func Network(config *structs.Config, node *structs.Node) error {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return fmt.Errorf("error listing network interfaces: %w", err)
+	}
+
+	defaultIface, gateway := config.NetworkInterface, ""
+	if defaultIface == "" {
+		defaultIface, gateway = defaultRoute()
+	}
+
+	hostNetworks := slices.Sorted(maps.Keys(config.HostNetworks))
+
+	var defaultNet *structs.NetworkResource
+	var nodeNets []*structs.NodeNetworkResource
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		if defaultIface == "" && iface.Flags&net.FlagLoopback == 0 {
+			// Without a default route use the first interface that is not
+			// loopback
+			defaultIface = iface.Name
+		}
+		isDefault := iface.Name == defaultIface
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			return fmt.Errorf("error listing addresses of %s: %w", iface.Name, err)
+		}
+
+		nodeNet := &structs.NodeNetworkResource{
+			Mode:       structs.NodeNetworkModeHost,
+			Device:     iface.Name,
+			MacAddress: iface.HardwareAddr.String(),
+			Speed:      interfaceSpeed(iface.Name, config.NetworkSpeed),
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ip := ipNet.IP
+			family, bits := structs.NodeNetworkAFIPv4, 32
+			if ip.To4() == nil {
+				family, bits = structs.NodeNetworkAFIPv6, 128
+			}
+
+			if isDefault {
+				addr := structs.NodeNetworkAddress{
+					Family:  family,
+					Alias:   structs.NodeNetworkAliasDefault,
+					Address: ip.String(),
+				}
+				if family == structs.NodeNetworkAFIPv4 {
+					addr.Gateway = gateway
+				}
+				nodeNet.Addresses = append(nodeNet.Addresses, addr)
+
+				// Prefer IPv4 for the default network
+				if defaultNet == nil || (family == structs.NodeNetworkAFIPv4 && net.ParseIP(defaultNet.IP).To4() == nil) {
+					defaultNet = &structs.NetworkResource{
+						Mode:   structs.NodeNetworkModeHost,
+						Device: iface.Name,
+						CIDR:   fmt.Sprintf("%s/%d", ip, bits),
+						IP:     ip.String(),
+						MBits:  nodeNet.Speed,
+					}
+				}
+			}
+
+			for _, name := range hostNetworks {
+				hn := config.HostNetworks[name]
+				if !hostNetworkMatches(hn, iface.Name, ip) {
+					continue
+				}
+				nodeNet.Addresses = append(nodeNet.Addresses, structs.NodeNetworkAddress{
+					Family:        family,
+					Alias:         name,
+					Address:       ip.String(),
+					ReservedPorts: hn.ReservedPorts,
+				})
+			}
+		}
+		if len(nodeNet.Addresses) > 0 {
+			nodeNets = append(nodeNets, nodeNet)
+		}
+	}
+
+	if defaultNet == nil {
+		return fmt.Errorf("no addresses found on default interface %q", defaultIface)
+	}
+
+	node.NodeResources.Networks = structs.Networks{defaultNet}
+	node.NodeResources.NodeNetworks = nodeNets
+	node.Attributes["unique.network.ip-address"] = defaultNet.IP
+	return nil
+}
+
+// hostNetworkMatches returns true if the address of the interface belongs to
+// the host network.
+func hostNetworkMatches(hn *structs.HostNetwork, iface string, ip net.IP) bool {
+	if hn.Interface != "" && hn.Interface != iface {
+		return false
+	}
+	if hn.CIDR != "" {
+		_, cidr, err := net.ParseCIDR(hn.CIDR)
+		if err != nil || !cidr.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// defaultRoute returns the interface and gateway of the IPv4 default route or
+// empty strings if there is none.
+func defaultRoute() (string, string) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields are: Iface Destination Gateway Flags RefCnt Use Metric Mask
+		// ... with addresses in little endian hex
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		gateway := ""
+		if b, err := hex.DecodeString(fields[2]); err == nil && len(b) == 4 {
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(b))
+			gateway = ip.String()
+		}
+		return fields[0], gateway
+	}
+	return "", ""
+}
+
+// interfaceSpeed returns the interface's speed in Mbit/s or fallback if it is
+// not reported, such as for virtual interfaces.
+func interfaceSpeed(iface string, fallback int) int {
+	buf, err := os.ReadFile(filepath.Join(netSysPath, iface, "speed"))
+	if err != nil {
+		return fallback
+	}
+	var speed int
+	if _, err := fmt.Sscan(string(buf), &speed); err != nil || speed <= 0 {
+		return fallback
+	}
+	return speed
+}
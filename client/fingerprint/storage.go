@@ -0,0 +1,77 @@
+package fingerprint
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// Storage sets the node's disk to the space available to unprivileged users
+// on the filesystem containing config.AllocDir. Like Nomad, it is only
+// fingerprinted when the node is built since free space changes constantly and
+// would cause the node to be updated on every refresh.
+func Storage(config *structs.Config, node *structs.Node) error {
+	path, err := filepath.Abs(config.AllocDir)
+	if err != nil {
+		return fmt.Errorf("error determining alloc dir: %w", err)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return fmt.Errorf("error determining disk space: %w", err)
+	}
+	total := stat.Blocks * uint64(stat.Bsize)
+	free := stat.Bavail * uint64(stat.Bsize)
+
+	node.NodeResources.Disk.DiskMB = int64(free / bytesPerMB)
+	node.Attributes["unique.storage.volume"] = mountSource(path)
+	node.Attributes["unique.storage.bytestotal"] = strconv.FormatUint(total, 10)
+	node.Attributes["unique.storage.bytesfree"] = strconv.FormatUint(free, 10)
+	return nil
+}
+
+// mountSource returns the device mounted at the mount point containing path
+// or path if it cannot be determined.
+func mountSource(path string) string {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return path
+	}
+	defer f.Close()
+
+	source, mountPoint := path, ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Fields are: ID parent major:minor root mount-point options
+		// [optional fields...] - fstype source super-options
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || sep+2 >= len(fields) {
+			continue
+		}
+		mp := fields[4]
+		if !within(path, mp) || len(mp) < len(mountPoint) {
+			continue
+		}
+		source, mountPoint = fields[sep+2], mp
+	}
+	return source
+}
+
+// within returns true if path is dir or inside it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
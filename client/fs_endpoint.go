@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/allocrunner/taskrunner"
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/structs"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	// logFrameSize is the maximum amount of log data sent per frame.
+	logFrameSize = 64 * 1024
+
+	// logPollInterval is how often followed logs are checked for new data.
+	logPollInterval = 250 * time.Millisecond
+)
+
+// fsLogs streams a task's stdout or stderr log to the caller, optionally
+// following it until the task exits or the caller disconnects.
+func (c *Client) fsLogs(conn io.ReadWriteCloser) {
+	dec := codec.NewDecoder(conn, msgpackHandle)
+	enc := codec.NewEncoder(conn, msgpackHandle)
+
+	req := &rpc.FsLogsRequest{}
+	if err := dec.Decode(req); err != nil {
+		sendStreamErr(enc, fmt.Errorf("error decoding request: %w", err), errCodeBadRequest)
+		return
+	}
+
+	if req.LogType != "stdout" && req.LogType != "stderr" {
+		sendStreamErr(enc, fmt.Errorf("invalid log type %q", req.LogType), errCodeBadRequest)
+		return
+	}
+	if req.Origin != "" && req.Origin != "start" && req.Origin != "end" {
+		sendStreamErr(enc, fmt.Errorf("invalid origin %q", req.Origin), errCodeBadRequest)
+		return
+	}
+
+	ar, err := c.getAllocRunner(req.AllocID)
+	if err != nil {
+		sendStreamErr(enc, err, errCodeNotFound)
+		return
+	}
+	tr := ar.Task(req.Task)
+	if tr == nil {
+		sendStreamErr(enc, fmt.Errorf("unknown task name %q", req.Task), errCodeNotFound)
+		return
+	}
+
+	path := taskrunner.LogPath(ar.AllocDir(), req.Task, req.LogType)
+	f, err := os.Open(path)
+	if err != nil {
+		code := errCodeInternal
+		if errors.Is(err, os.ErrNotExist) {
+			code = errCodeNotFound
+		}
+		sendStreamErr(enc, fmt.Errorf("error opening log: %w", err), code)
+		return
+	}
+	defer f.Close()
+
+	offset := req.Offset
+	if req.Origin == "end" {
+		fi, err := f.Stat()
+		if err != nil {
+			sendStreamErr(enc, err, errCodeInternal)
+			return
+		}
+		offset = max(fi.Size()-req.Offset, 0)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		sendStreamErr(enc, err, errCodeInternal)
+		return
+	}
+
+	// The caller closes its side of the stream when it is no longer
+	// interested in the logs.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var discard any
+		for dec.Decode(&discard) == nil {
+		}
+		cancel()
+	}()
+
+	buf := make([]byte, logFrameSize)
+	for {
+		// Check before reading so output written just before the task
+		// exited is not missed.
+		dead := tr.State().State == structs.TaskStateDead
+
+		n, err := f.Read(buf)
+		if n > 0 {
+			offset += int64(n)
+			frame := &rpc.StreamFrame{
+				Offset: offset,
+				Data:   buf[:n],
+				File:   filepath.Base(path),
+			}
+			if err := sendStreamPayload(enc, frame); err != nil {
+				return
+			}
+		}
+
+		switch {
+		case err == nil:
+			continue
+		case !errors.Is(err, io.EOF):
+			sendStreamErr(enc, err, errCodeInternal)
+			return
+		case !req.Follow || dead:
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(logPollInterval):
+		}
+	}
+}
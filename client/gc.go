@@ -0,0 +1,138 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/allocrunner"
+)
+
+// gc periodically garbage collects terminal allocs until ctx is done.
+func (c *Client) gc(ctx context.Context) {
+	defer c.log.Debug("alloc garbage collector exited")
+
+	conf := c.Config().GC
+	if conf.Interval <= 0 {
+		c.log.Warn("alloc garbage collection disabled", "interval", conf.Interval)
+		return
+	}
+
+	ticker := time.NewTicker(conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.collectAllocs()
+	}
+}
+
+// collectAllocs destroys terminal allocs, oldest first, while any GC limit is
+// exceeded. Allocs still listed by servers are never collected.
+func (c *Client) collectAllocs() {
+	conf := c.Config().GC
+
+	candidates, total := c.gcCandidates()
+	for _, ar := range candidates {
+		reason := ""
+		switch {
+		case conf.MaxAge > 0 && time.Since(ar.FinishedAt()) > conf.MaxAge:
+			reason = "max age exceeded"
+		case conf.MaxAllocs > 0 && total > conf.MaxAllocs:
+			reason = "max allocs exceeded"
+		default:
+			disk, inodes, err := diskUsage(c.Config().AllocDir)
+			switch {
+			case err != nil:
+				c.log.Error("error determining disk usage", "error", err)
+			case disk > conf.DiskUsageThreshold:
+				reason = fmt.Sprintf("disk usage %.1f%% exceeds threshold", disk)
+			case inodes > conf.InodeUsageThreshold:
+				reason = fmt.Sprintf("inode usage %.1f%% exceeds threshold", inodes)
+			}
+		}
+		if reason == "" {
+			// Remaining candidates are newer and limits are not exceeded
+			return
+		}
+
+		c.destroyAlloc(ar, reason)
+		total--
+	}
+}
+
+// gcCandidates returns terminal allocs that servers no longer list ordered by
+// when they finished, and the total number of allocs.
+func (c *Client) gcCandidates() ([]*allocrunner.AllocRunner, int) {
+	c.allocsMu.RLock()
+	defer c.allocsMu.RUnlock()
+
+	if c.serverAllocs == nil {
+		// Restored allocs may still be listed by servers
+		return nil, len(c.allocs)
+	}
+
+	var candidates []*allocrunner.AllocRunner
+	for id, ar := range c.allocs {
+		if _, ok := c.serverAllocs[id]; ok {
+			continue
+		}
+		select {
+		case <-ar.WaitCh():
+			candidates = append(candidates, ar)
+		default:
+		}
+	}
+
+	slices.SortFunc(candidates, func(a, b *allocrunner.AllocRunner) int {
+		return a.FinishedAt().Compare(b.FinishedAt())
+	})
+	return candidates, len(c.allocs)
+}
+
+// destroyAlloc removes a terminal alloc, its persisted state, and its alloc
+// dir.
+func (c *Client) destroyAlloc(ar *allocrunner.AllocRunner, reason string) {
+	allocID := ar.ID()
+
+	c.allocsMu.Lock()
+	if _, ok := c.serverAllocs[allocID]; ok {
+		// Listed again since becoming a candidate
+		c.allocsMu.Unlock()
+		return
+	}
+	delete(c.allocs, allocID)
+	c.allocsMu.Unlock()
+	c.deleteAllocState(allocID)
+
+	if err := os.RemoveAll(ar.AllocDir()); err != nil {
+		c.log.Error("error removing alloc dir", "alloc_id", allocID, "error", err)
+	}
+	c.log.Info("garbage collected alloc", "alloc_id", allocID, "reason", reason)
+}
+
+// diskUsage returns the percentage of blocks and inodes used on the filesystem
+// containing path.
+func diskUsage(path string) (float64, float64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	var disk, inodes float64
+	// Like df, blocks reserved for root are not counted as available
+	if used := stat.Blocks - stat.Bfree; used+stat.Bavail > 0 {
+		disk = float64(used) / float64(used+stat.Bavail) * 100
+	}
+	if stat.Files > 0 {
+		inodes = float64(stat.Files-stat.Ffree) / float64(stat.Files) * 100
+	}
+	return disk, inodes, nil
+}
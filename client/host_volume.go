@@ -0,0 +1,220 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+
+	"github.com/schmichael/nomadlet/client/hostvolume"
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+type hostVolumeEndpoint struct {
+	c *Client
+}
+
+// Create creates a dynamic host volume with a plugin and adds it to the node.
+// Creating an existing volume runs the plugin again so it may update the
+// volume.
+func (e *hostVolumeEndpoint) Create(args *rpc.ClientHostVolumeCreateRequest, reply *rpc.ClientHostVolumeCreateResponse) error {
+	if args.ID == "" || args.Name == "" || args.PluginID == "" {
+		return errors.New("missing required volume ID, name, or plugin ID")
+	}
+
+	c := e.c
+	c.hostVolumesMu.Lock()
+	defer c.hostVolumesMu.Unlock()
+
+	if err := c.checkHostVolumeName(args.ID, args.Name); err != nil {
+		return err
+	}
+
+	config := c.Config()
+	plugin, err := hostvolume.Lookup(config.HostVolumePluginDir, config.HostVolumesDir, args.PluginID)
+	if err != nil {
+		return err
+	}
+	req := &hostvolume.Request{
+		ID:               args.ID,
+		Name:             args.Name,
+		Namespace:        args.Namespace,
+		NodeID:           c.getNode().ID,
+		NodePool:         config.NodePool,
+		CapacityMinBytes: args.RequestedCapacityMinBytes,
+		CapacityMaxBytes: args.RequestedCapacityMaxBytes,
+		Parameters:       args.Parameters,
+	}
+	resp, err := plugin.Create(context.Background(), req)
+	if err != nil {
+		return err
+	}
+
+	hv := &structs.HostVolumeState{
+		ID:            args.ID,
+		Name:          args.Name,
+		PluginID:      args.PluginID,
+		HostPath:      resp.Path,
+		CapacityBytes: resp.Bytes,
+	}
+	if err := c.setHostVolume(args.ID, hv); err != nil {
+		// Do not leak the volume as servers will retry creating it
+		req.CreatedPath = resp.Path
+		if derr := plugin.Delete(context.Background(), req); derr != nil {
+			c.log.Error("error cleaning up host volume", "volume_id", args.ID, "error", derr)
+		}
+		return err
+	}
+
+	c.log.Info("created host volume", "volume_id", args.ID, "name", args.Name, "plugin_id", args.PluginID, "path", resp.Path)
+	*reply = rpc.ClientHostVolumeCreateResponse{
+		VolumeName:    args.Name,
+		VolumeID:      args.ID,
+		HostPath:      resp.Path,
+		CapacityBytes: resp.Bytes,
+	}
+	return nil
+}
+
+// Register adds an existing directory to the node as a dynamic host volume.
+func (e *hostVolumeEndpoint) Register(args *rpc.ClientHostVolumeRegisterRequest, reply *rpc.ClientHostVolumeRegisterResponse) error {
+	if args.ID == "" || args.Name == "" || args.HostPath == "" {
+		return errors.New("missing required volume ID, name, or host path")
+	}
+	if !filepath.IsAbs(args.HostPath) {
+		return fmt.Errorf("host path %q must be absolute", args.HostPath)
+	}
+	fi, err := os.Stat(args.HostPath)
+	if err != nil {
+		return fmt.Errorf("invalid host path: %w", err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("host path %q is not a directory", args.HostPath)
+	}
+
+	c := e.c
+	c.hostVolumesMu.Lock()
+	defer c.hostVolumesMu.Unlock()
+
+	if err := c.checkHostVolumeName(args.ID, args.Name); err != nil {
+		return err
+	}
+	hv := &structs.HostVolumeState{
+		ID:            args.ID,
+		Name:          args.Name,
+		HostPath:      filepath.Clean(args.HostPath),
+		CapacityBytes: args.CapacityBytes,
+	}
+	if err := c.setHostVolume(args.ID, hv); err != nil {
+		return err
+	}
+
+	c.log.Info("registered host volume", "volume_id", args.ID, "name", args.Name, "path", hv.HostPath)
+	return nil
+}
+
+// Delete deletes a dynamic host volume with the plugin that created it and
+// removes it from the node. Registered volumes are only removed from the node.
+// Deleting an unknown volume runs the plugin in case it was created before
+// nomadlet lost its state.
+func (e *hostVolumeEndpoint) Delete(args *rpc.ClientHostVolumeDeleteRequest, reply *rpc.ClientHostVolumeDeleteResponse) error {
+	if args.ID == "" {
+		return errors.New("missing required volume ID")
+	}
+
+	c := e.c
+	c.hostVolumesMu.Lock()
+	defer c.hostVolumesMu.Unlock()
+
+	c.stateMu.Lock()
+	hv := c.state.HostVolumes[args.ID]
+	c.stateMu.Unlock()
+
+	pluginID, path := args.PluginID, args.HostPath
+	if hv != nil {
+		pluginID, path = hv.PluginID, hv.HostPath
+	}
+
+	if pluginID != "" {
+		config := c.Config()
+		plugin, err := hostvolume.Lookup(config.HostVolumePluginDir, config.HostVolumesDir, pluginID)
+		if err != nil {
+			return err
+		}
+		req := &hostvolume.Request{
+			ID:          args.ID,
+			Name:        args.Name,
+			Namespace:   args.Namespace,
+			NodeID:      c.getNode().ID,
+			NodePool:    config.NodePool,
+			Parameters:  args.Parameters,
+			CreatedPath: path,
+		}
+		if err := plugin.Delete(context.Background(), req); err != nil {
+			return err
+		}
+	}
+
+	if hv != nil {
+		if err := c.setHostVolume(args.ID, nil); err != nil {
+			return err
+		}
+		c.log.Info("deleted host volume", "volume_id", args.ID, "name", hv.Name)
+	}
+
+	*reply = rpc.ClientHostVolumeDeleteResponse{
+		VolumeName: args.Name,
+		VolumeID:   args.ID,
+	}
+	return nil
+}
+
+// checkHostVolumeName returns an error if a different host volume already
+// uses the name.
+func (c *Client) checkHostVolumeName(id, name string) error {
+	if _, ok := c.Config().HostVolumes[name]; ok {
+		return fmt.Errorf("host volume %q already exists as a static host volume", name)
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	for _, hv := range c.state.HostVolumes {
+		if hv.Name == name && hv.ID != id {
+			return fmt.Errorf("host volume %q already exists with ID %s", name, hv.ID)
+		}
+	}
+	return nil
+}
+
+// setHostVolume persists a dynamic host volume, or removes it if hv is nil,
+// and re-registers the node with its new host volumes.
+func (c *Client) setHostVolume(id string, hv *structs.HostVolumeState) error {
+	c.stateMu.Lock()
+	prev := c.state.HostVolumes
+	volumes := maps.Clone(prev)
+	if volumes == nil {
+		volumes = map[string]*structs.HostVolumeState{}
+	}
+	if hv == nil {
+		delete(volumes, id)
+	} else {
+		volumes[id] = hv
+	}
+	c.state.HostVolumes = volumes
+	if err := c.state.Store(c.Config().StatePath); err != nil {
+		c.state.HostVolumes = prev
+		c.stateMu.Unlock()
+		return fmt.Errorf("error persisting host volume: %w", err)
+	}
+	defer c.stateMu.Unlock()
+
+	node, err := c.makeNode(c.Config())
+	if err != nil {
+		return err
+	}
+	c.setNode(node)
+	return nil
+}
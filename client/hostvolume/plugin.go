@@ -0,0 +1,274 @@
+// Package hostvolume creates and deletes dynamic host volumes with plugins.
+//
+// The builtin mkdir plugin creates a directory per volume in the volumes dir.
+// Other plugins are executables in the plugin dir named by their plugin ID.
+// They are run with the operation as their only argument and the volume in
+// DHV_* environment variables, and report their results as JSON on stdout:
+//
+//	fingerprint: {"version": "0.0.1"}
+//	create:      {"path": "/srv/volumes/web", "bytes": 1073741824}
+//	delete:      no output
+package hostvolume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MkdirPluginID is the ID of the builtin plugin.
+	MkdirPluginID      = "mkdir"
+	mkdirPluginVersion = "0.0.1"
+
+	// fingerprintTimeout and operationTimeout limit how long plugins may run.
+	fingerprintTimeout = 10 * time.Second
+	operationTimeout   = time.Minute
+)
+
+// Request identifies a volume to create or delete.
+type Request struct {
+	ID        string
+	Name      string
+	Namespace string
+	NodeID    string
+	NodePool  string
+
+	CapacityMinBytes int64
+	CapacityMaxBytes int64
+	Parameters       map[string]string
+
+	// CreatedPath is the path returned by create. Only set when deleting.
+	CreatedPath string
+}
+
+// CreateResponse is the volume created by a plugin.
+type CreateResponse struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// Plugin creates and deletes host volumes. Both operations must be
+// idempotent as servers retry them.
+type Plugin interface {
+	Create(ctx context.Context, req *Request) (*CreateResponse, error)
+	Delete(ctx context.Context, req *Request) error
+}
+
+// Lookup returns the plugin with the ID.
+func Lookup(pluginDir, volumesDir, id string) (Plugin, error) {
+	if err := validName(id); err != nil {
+		return nil, fmt.Errorf("invalid plugin id: %w", err)
+	}
+	if id == MkdirPluginID {
+		return &mkdirPlugin{volumesDir: volumesDir}, nil
+	}
+	path := filepath.Join(pluginDir, id)
+	if err := executable(path); err != nil {
+		return nil, fmt.Errorf("plugin %q not found: %w", id, err)
+	}
+	return &execPlugin{id: id, path: path, pluginDir: pluginDir, volumesDir: volumesDir}, nil
+}
+
+// Fingerprint returns the versions of the plugins keyed by ID, including the
+// builtin plugin. Plugins that fail to fingerprint are returned as errors.
+func Fingerprint(pluginDir string) (map[string]string, error) {
+	versions := map[string]string{MkdirPluginID: mkdirPluginVersion}
+
+	entries, err := os.ReadDir(pluginDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return versions, nil
+	}
+	if err != nil {
+		return versions, fmt.Errorf("error reading plugin dir: %w", err)
+	}
+
+	var errs []error
+	for _, e := range entries {
+		id := e.Name()
+		if id == MkdirPluginID || executable(filepath.Join(pluginDir, id)) != nil {
+			continue
+		}
+		p := &execPlugin{id: id, path: filepath.Join(pluginDir, id), pluginDir: pluginDir}
+		version, err := p.fingerprint()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		versions[id] = version
+	}
+	return versions, errors.Join(errs...)
+}
+
+// mkdirPlugin creates a directory named by the volume ID in the volumes dir.
+type mkdirPlugin struct {
+	volumesDir string
+}
+
+func (p *mkdirPlugin) Create(ctx context.Context, req *Request) (*CreateResponse, error) {
+	path, err := p.path(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating volume: %w", err)
+	}
+	return &CreateResponse{Path: path}, nil
+}
+
+func (p *mkdirPlugin) Delete(ctx context.Context, req *Request) error {
+	path, err := p.path(req.ID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("error deleting volume: %w", err)
+	}
+	return nil
+}
+
+// path returns the absolute path of the volume's directory.
+func (p *mkdirPlugin) path(id string) (string, error) {
+	if err := validName(id); err != nil {
+		return "", fmt.Errorf("invalid volume id: %w", err)
+	}
+	return filepath.Abs(filepath.Join(p.volumesDir, id))
+}
+
+// execPlugin runs an executable for each operation.
+type execPlugin struct {
+	id         string
+	path       string
+	pluginDir  string
+	volumesDir string
+}
+
+func (p *execPlugin) fingerprint() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), fingerprintTimeout)
+	defer cancel()
+
+	var resp struct {
+		Version string `json:"version"`
+	}
+	if err := p.run(ctx, "fingerprint", nil, &resp); err != nil {
+		return "", err
+	}
+	if resp.Version == "" {
+		return "", fmt.Errorf("plugin %q fingerprint: missing version", p.id)
+	}
+	return resp.Version, nil
+}
+
+func (p *execPlugin) Create(ctx context.Context, req *Request) (*CreateResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	var resp CreateResponse
+	if err := p.run(ctx, "create", req, &resp); err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(resp.Path) {
+		return nil, fmt.Errorf("plugin %q create: path %q is not absolute", p.id, resp.Path)
+	}
+	return &resp, nil
+}
+
+func (p *execPlugin) Delete(ctx context.Context, req *Request) error {
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+	return p.run(ctx, "delete", req, nil)
+}
+
+// run runs the plugin and decodes its stdout into resp if it is not nil.
+func (p *execPlugin) run(ctx context.Context, op string, req *Request, resp any) error {
+	env, err := p.env(op, req)
+	if err != nil {
+		return err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.path, op)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return fmt.Errorf("plugin %q %s: %w", p.id, op, err)
+	}
+
+	if resp != nil {
+		if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+			return fmt.Errorf("plugin %q %s: invalid output: %w", p.id, op, err)
+		}
+	}
+	return nil
+}
+
+// env returns the DHV_* environment variables describing the operation.
+func (p *execPlugin) env(op string, req *Request) ([]string, error) {
+	env := []string{
+		"DHV_OPERATION=" + op,
+		"DHV_PLUGIN_DIR=" + p.pluginDir,
+	}
+	if req == nil {
+		return env, nil
+	}
+
+	params, err := json.Marshal(req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	volumesDir, err := filepath.Abs(p.volumesDir)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env,
+		"DHV_VOLUMES_DIR="+volumesDir,
+		"DHV_VOLUME_ID="+req.ID,
+		"DHV_VOLUME_NAME="+req.Name,
+		"DHV_NAMESPACE="+req.Namespace,
+		"DHV_NODE_ID="+req.NodeID,
+		"DHV_NODE_POOL="+req.NodePool,
+		"DHV_PARAMETERS="+string(params),
+	)
+	if op == "create" {
+		env = append(env,
+			"DHV_CAPACITY_MIN_BYTES="+strconv.FormatInt(req.CapacityMinBytes, 10),
+			"DHV_CAPACITY_MAX_BYTES="+strconv.FormatInt(req.CapacityMaxBytes, 10),
+		)
+	}
+	if op == "delete" {
+		env = append(env, "DHV_CREATED_PATH="+req.CreatedPath)
+	}
+	return env, nil
+}
+
+// validName returns an error if name is not a single path element.
+func validName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%q must be a file name", name)
+	}
+	return nil
+}
+
+// executable returns an error if path is not an executable file.
+func executable(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() || fi.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("%s is not an executable file", path)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// MetricsSummary is the subset of the go-metrics summary served by Nomad
+// agents' /v1/metrics endpoint that nomadlet reports.
+type MetricsSummary struct {
+	Timestamp string
+	Gauges    []GaugeValue
+	Points    []any
+	Counters  []any
+	Samples   []any
+}
+
+// GaugeValue is a gauge's current value.
+type GaugeValue struct {
+	Name   string
+	Value  float32
+	Labels map[string]string
+}
+
+// HTTPHandler returns a handler serving /v1/metrics.
+func (c *Client) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(c.Metrics()); err != nil {
+			c.log.Debug("error writing metrics", "error", err)
+		}
+	})
+	return mux
+}
+
+// Metrics returns the node's drain and scheduling eligibility gauges labeled
+// like Nomad's client metrics. The drain deadline gauge is only reported while
+// draining with a deadline.
+func (c *Client) Metrics() *MetricsSummary {
+	node := c.getNode()
+	labels := map[string]string{
+		"node_id":    node.ID,
+		"datacenter": node.Datacenter,
+		"node_class": node.NodeClass,
+		"node_pool":  node.NodePool,
+	}
+
+	now := time.Now()
+	c.nodeMu.Lock()
+	eligible := c.eligibility == structs.NodeSchedulingEligible
+	drain := c.drain
+	c.nodeMu.Unlock()
+
+	gauges := []GaugeValue{
+		{Name: "nomad.client.draining", Value: boolGauge(drain != nil), Labels: labels},
+		{Name: "nomad.client.scheduling_eligible", Value: boolGauge(eligible), Labels: labels},
+	}
+	if drain != nil && !drain.ForceDeadline.IsZero() {
+		remaining := max(drain.ForceDeadline.Sub(now), 0)
+		gauges = append(gauges, GaugeValue{
+			Name:   "nomad.client.drain_deadline_seconds",
+			Value:  float32(remaining.Seconds()),
+			Labels: labels,
+		})
+	}
+
+	return &MetricsSummary{
+		Timestamp: now.UTC().Format("2006-01-02 15:04:05 -0700 MST"),
+		Gauges:    gauges,
+		Points:    []any{},
+		Counters:  []any{},
+		Samples:   []any{},
+	}
+}
+
+func boolGauge(b bool) float32 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"maps"
+	"reflect"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// makeNode builds the node from config and fingerprints it. Must be called
+// with stateMu held.
+func (c *Client) makeNode(config *structs.Config) (*structs.Node, error) {
+	node, err := structs.MakeNode(c.state, config)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range c.fingerprinters() {
+		if err := f.Fingerprint(config, node); err != nil {
+			c.log.Warn("error fingerprinting node", "fingerprinter", f.Name, "error", err)
+			if f.Fallback != nil {
+				f.Fallback(config, node)
+			}
+		}
+	}
+	return node, nil
+}
+
+// getNode returns the node to register. It must not be modified.
+func (c *Client) getNode() *structs.Node {
+	c.nodeMu.Lock()
+	defer c.nodeMu.Unlock()
+	return c.node
+}
+
+// setNode replaces the node and re-registers it if it changed. Returns true if
+// the node changed.
+func (c *Client) setNode(node *structs.Node) bool {
+	c.nodeMu.Lock()
+	changed := nodeUpdated(c.node, node)
+	if changed {
+		c.node = node
+	}
+	c.nodeMu.Unlock()
+
+	if changed {
+		select {
+		case c.nodeUpdateCh <- struct{}{}:
+		default:
+		}
+	}
+	return changed
+}
+
+// nodeUpdated returns true if the fields of the node sent when registering
+// differ. Fingerprints must not include volatile values, such as free disk
+// space or the current cpu frequency, as any change re-registers the node.
+func nodeUpdated(prev, next *structs.Node) bool {
+	return prev.Name != next.Name ||
+		prev.Datacenter != next.Datacenter ||
+		prev.NodeClass != next.NodeClass ||
+		prev.NodePool != next.NodePool ||
+		!maps.Equal(prev.Meta, next.Meta) ||
+		!maps.Equal(prev.Attributes, next.Attributes) ||
+		!reflect.DeepEqual(prev.NodeResources, next.NodeResources) ||
+		!reflect.DeepEqual(prev.HostVolumes, next.HostVolumes) ||
+		!reflect.DeepEqual(prev.ReservedResources, next.ReservedResources) ||
+		!reflect.DeepEqual(prev.Drivers, next.Drivers)
+}
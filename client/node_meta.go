@@ -0,0 +1,78 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+
+	"github.com/schmichael/nomadlet/internal/rpc"
+)
+
+type nodeMetaEndpoint struct {
+	c *Client
+}
+
+// Apply updates the node's dynamic meta. Keys set to nil are removed from
+// the node's meta even if configured.
+func (e *nodeMetaEndpoint) Apply(args *rpc.NodeMetaApplyRequest, reply *rpc.NodeMetaResponse) error {
+	if len(args.Meta) == 0 {
+		return errors.New("missing required Meta object")
+	}
+	for k := range args.Meta {
+		if k == "" {
+			return errors.New("meta keys must not be empty")
+		}
+	}
+	if err := e.c.applyDynamicMeta(args.Meta); err != nil {
+		return err
+	}
+	*reply = e.c.nodeMeta()
+	return nil
+}
+
+// Read returns the node's meta.
+func (e *nodeMetaEndpoint) Read(args *rpc.NodeSpecificRequest, reply *rpc.NodeMetaResponse) error {
+	*reply = e.c.nodeMeta()
+	return nil
+}
+
+// applyDynamicMeta merges meta into the dynamic meta, persists it, and
+// re-registers the node with its new meta.
+func (c *Client) applyDynamicMeta(meta map[string]*string) error {
+	c.stateMu.Lock()
+	prev := c.state.DynamicMeta
+	dynamic := maps.Clone(prev)
+	if dynamic == nil {
+		dynamic = map[string]*string{}
+	}
+	maps.Copy(dynamic, meta)
+	c.state.DynamicMeta = dynamic
+	if err := c.state.Store(c.Config().StatePath); err != nil {
+		c.state.DynamicMeta = prev
+		c.stateMu.Unlock()
+		return fmt.Errorf("error persisting dynamic meta: %w", err)
+	}
+	defer c.stateMu.Unlock()
+
+	node, err := c.makeNode(c.Config())
+	if err != nil {
+		return err
+	}
+	if c.setNode(node) {
+		c.log.Info("applied dynamic node meta", "meta", node.Meta)
+	}
+	return nil
+}
+
+// nodeMeta returns the node's static, dynamic, and merged meta.
+func (c *Client) nodeMeta() rpc.NodeMetaResponse {
+	c.stateMu.Lock()
+	dynamic := maps.Clone(c.state.DynamicMeta)
+	c.stateMu.Unlock()
+
+	return rpc.NodeMetaResponse{
+		Meta:    c.getNode().Meta,
+		Dynamic: dynamic,
+		Static:  c.Config().Meta,
+	}
+}
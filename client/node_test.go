@@ -0,0 +1,51 @@
+package client
+
+import "testing"
+
+func TestClient_RefingerprintUnchanged(t *testing.T) {
+	c, err := NewClient(testConfig(t, "127.0.0.1:4647"))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	// Refreshing fingerprints when nothing about the host changed must not
+	// re-register the node
+	for _, f := range c.fingerprinters() {
+		changed, err := c.refreshFingerprint(f)
+		if err != nil {
+			t.Logf("%s: error fingerprinting: %v", f.Name, err)
+		}
+		if changed {
+			t.Errorf("%s: expected node to be unchanged", f.Name)
+		}
+	}
+	select {
+	case <-c.nodeUpdateCh:
+		t.Fatalf("expected no node update")
+	default:
+	}
+
+	// Modifying a copy does not modify the registered node
+	prev := c.getNode()
+	node := prev.Copy()
+	node.Attributes["unique.test"] = "1"
+	node.NodeResources.Processors.Topology.Cores[0].Disable = !prev.NodeResources.Processors.Topology.Cores[0].Disable
+	if !nodeUpdated(prev, node) {
+		t.Fatalf("expected change to be detected")
+	}
+	if _, ok := prev.Attributes["unique.test"]; ok {
+		t.Errorf("expected attributes to be copied")
+	}
+	if prev.NodeResources.Processors.Topology.Cores[0].Disable == node.NodeResources.Processors.Topology.Cores[0].Disable {
+		t.Errorf("expected cores to be copied")
+	}
+
+	if !c.setNode(node) {
+		t.Fatalf("expected node to be updated")
+	}
+	select {
+	case <-c.nodeUpdateCh:
+	default:
+		t.Fatalf("expected node update")
+	}
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/schmichael/nomadlet/client/driver"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// Config returns the current configuration. It must not be modified.
+func (c *Client) Config() *structs.Config {
+	c.configMu.Lock()
+	defer c.configMu.Unlock()
+	return c.config
+}
+
+// Reload applies the settings in config that can be changed without
+// restarting and logs which settings were applied and which require a
+// restart. TLS certificates are always reloaded from disk. The node is
+// re-registered if any fields visible to the servers changed.
+//
+// The current configuration is kept if config is invalid.
+func (c *Client) Reload(config *structs.Config) error {
+	level, err := structs.ParseLogLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	if len(config.Servers) == 0 {
+		return errors.New("no servers configured")
+	}
+
+	// Drivers are only configured when starting, but invalid plugin configs
+	// are reported now rather than when restarting
+	if _, err := driver.NewRegistry(c.log, config.Plugins); err != nil {
+		return err
+	}
+
+	prev := c.Config()
+	applied, restart := diffConfig(prev, config)
+
+	// Keep the settings that require a restart
+	next := *config
+	next.Region = prev.Region
+	next.Datacenter = prev.Datacenter
+	next.StatePath = prev.StatePath
+	next.AllocDir = prev.AllocDir
+	next.GC.Interval = prev.GC.Interval
+	next.Plugins = prev.Plugins
+
+	if err := c.rpc.ReloadTLS(next.TLS); err != nil {
+		return fmt.Errorf("error reloading tls: %w", err)
+	}
+
+	// Hold stateMu so the node is not concurrently rebuilt from the previous
+	// config when applying dynamic meta
+	c.stateMu.Lock()
+	node, err := c.makeNode(&next)
+	if err != nil {
+		c.stateMu.Unlock()
+		return err
+	}
+	c.configMu.Lock()
+	c.config = &next
+	c.configMu.Unlock()
+	nodeChanged := c.setNode(node)
+	c.stateMu.Unlock()
+
+	c.logLevel.Set(level)
+	c.rpc.SetSeeds(next.Servers)
+
+	c.log.Info("reloaded configuration", "applied", applied, "node_updated", nodeChanged)
+	if len(restart) > 0 {
+		c.log.Warn("ignoring configuration changes that require a restart", "settings", restart)
+	}
+	return nil
+}
+
+// diffConfig returns the names of the settings that differ between prev and
+// next split by whether they can be applied without restarting. Names match
+// the config file keys.
+func diffConfig(prev, next *structs.Config) (applied, restart []string) {
+	diff := func(name string, changed, reloadable bool) {
+		switch {
+		case !changed:
+		case reloadable:
+			applied = append(applied, name)
+		default:
+			restart = append(restart, name)
+		}
+	}
+
+	diff("region", prev.Region != next.Region, false)
+	diff("datacenter", prev.Datacenter != next.Datacenter, false)
+	diff("name", prev.Name != next.Name, true)
+	diff("log_level", prev.LogLevel != next.LogLevel, true)
+	diff("leave_on_interrupt", prev.LeaveOnInterrupt != next.LeaveOnInterrupt, true)
+	diff("leave_on_terminate", prev.LeaveOnTerminate != next.LeaveOnTerminate, true)
+	diff("tls", prev.TLS != next.TLS, true)
+
+	diff("client.servers", !slices.Equal(prev.Servers, next.Servers), true)
+	diff("client.state_file", prev.StatePath != next.StatePath, false)
+	diff("client.alloc_dir", prev.AllocDir != next.AllocDir, false)
+	diff("client.meta", !maps.Equal(prev.Meta, next.Meta), true)
+	diff("client.node_class", prev.NodeClass != next.NodeClass, true)
+	diff("client.node_pool", prev.NodePool != next.NodePool, true)
+	diff("client.cpu_cores", prev.Cores != next.Cores, true)
+	diff("client.cpu_total_compute", prev.Mhz != next.Mhz, true)
+	diff("client.memory_total_mb", prev.Mem != next.Mem, true)
+	diff("client.reserved", !reflect.DeepEqual(prev.Reserved, next.Reserved), true)
+	diff("client.network_interface", prev.NetworkInterface != next.NetworkInterface, true)
+	diff("client.network_speed", prev.NetworkSpeed != next.NetworkSpeed, true)
+	diff("client.host_network", !reflect.DeepEqual(prev.HostNetworks, next.HostNetworks), true)
+	diff("client.host_volume", !reflect.DeepEqual(prev.HostVolumes, next.HostVolumes), true)
+	diff("client.host_volumes_dir", prev.HostVolumesDir != next.HostVolumesDir, true)
+	diff("client.host_volume_plugin_dir", prev.HostVolumePluginDir != next.HostVolumePluginDir, true)
+	diff("client.drain_on_shutdown", prev.DrainOnShutdown != next.DrainOnShutdown, true)
+	diff("client.stop_after_client_disconnect", prev.StopAfterClientDisconnect != next.StopAfterClientDisconnect, true)
+	diff("client.gc_interval", prev.GC.Interval != next.GC.Interval, false)
+	diff("client.gc_max_allocs", prev.GC.MaxAllocs != next.GC.MaxAllocs, true)
+	diff("client.gc_disk_usage_threshold", prev.GC.DiskUsageThreshold != next.GC.DiskUsageThreshold, true)
+	diff("client.gc_inode_usage_threshold", prev.GC.InodeUsageThreshold != next.GC.InodeUsageThreshold, true)
+	diff("client.gc_max_age", prev.GC.MaxAge != next.GC.MaxAge, true)
+
+	diff("plugin", !reflect.DeepEqual(prev.Plugins, next.Plugins), false)
+
+	return applied, restart
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"path/filepath"
+
+	"github.com/schmichael/nomadlet/client/allocrunner"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// restoreAllocs starts alloc runners for the allocs persisted before nomadlet
+// restarted, reattaching to tasks that are still running. Servers are
+// consulted once the alloc watcher starts, which stops allocs removed while
+// nomadlet was down.
+func (c *Client) restoreAllocs() {
+	states, err := structs.AllocStatesLoad(c.allocStateDir())
+	if err != nil {
+		c.log.Error("error loading alloc state; not restoring allocs", "error", err)
+		return
+	}
+	restored := make(map[string]*structs.AllocState, len(states))
+	for id, as := range states {
+		if as == nil || as.Alloc == nil || as.Alloc.ID != id {
+			c.log.Warn("discarding invalid alloc state", "alloc_id", id)
+			if err := structs.AllocStateDelete(c.allocStateDir(), id); err != nil {
+				c.log.Error("error removing alloc state", "alloc_id", id, "error", err)
+			}
+			continue
+		}
+		restored[id] = as
+	}
+
+	c.allocsMu.Lock()
+	defer c.allocsMu.Unlock()
+	for id, as := range restored {
+		c.log.Info("restoring alloc", "alloc_id", id)
+		ar := c.newAllocRunner(id, as.Alloc.AllocModifyIndex, as)
+		c.allocs[id] = ar
+		go ar.Run()
+	}
+}
+
+// newAllocRunner returns an alloc runner for an alloc, restoring it from
+// restore if it is not nil.
+func (c *Client) newAllocRunner(allocID string, index uint64, restore *structs.AllocState) *allocrunner.AllocRunner {
+	return allocrunner.New(allocrunner.Config{
+		AllocID:      allocID,
+		AllocDir:     filepath.Join(c.Config().AllocDir, allocID),
+		ModifyIndex:  index,
+		RPC:          c.rpc,
+		StateUpdater: c,
+		StateDB:      c,
+		Logger:       c.log.With("alloc_id", allocID),
+		Drivers:      c.drivers,
+		HostVolumes:  c.getNode().HostVolumes,
+		Restore:      restore,
+	})
+}
+
+// allocStateDir contains a file per alloc with its local state. It is next to
+// the state file.
+func (c *Client) allocStateDir() string {
+	return filepath.Join(filepath.Dir(c.Config().StatePath), "alloc_state")
+}
+
+// PutAllocState persists an alloc's local state. State for allocs that are no
+// longer running on this node is ignored.
+func (c *Client) PutAllocState(as *structs.AllocState) {
+	c.allocStateMu.Lock()
+	defer c.allocStateMu.Unlock()
+
+	c.allocsMu.RLock()
+	_, ok := c.allocs[as.Alloc.ID]
+	c.allocsMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if err := as.Store(c.allocStateDir()); err != nil {
+		c.log.Error("error persisting alloc state", "alloc_id", as.Alloc.ID, "error", err)
+	}
+}
+
+// deleteAllocState removes an alloc's persisted state. The alloc must already
+// be removed from allocs so its state is not persisted again.
+func (c *Client) deleteAllocState(allocID string) {
+	c.allocStateMu.Lock()
+	defer c.allocStateMu.Unlock()
+	if err := structs.AllocStateDelete(c.allocStateDir(), allocID); err != nil {
+		c.log.Error("error removing alloc state", "alloc_id", allocID, "error", err)
+	}
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/schmichael/nomadlet/client/allocrunner"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// drainPollInterval is how often to check whether a self-drain has
+	// stopped all allocations.
+	drainPollInterval = time.Second
+)
+
+// Shutdown must be called before cancelling the context passed to Run.
+//
+// If leave is false nomadlet is restarting: tasks are left running so they can
+// be reattached and only pending alloc updates are sent.
+//
+// If leave is true the node optionally drains itself, stops all allocations
+// honoring their kill timeouts, sends their final status, and marks itself
+// down so the servers do not wait for the heartbeat TTL to expire before
+// rescheduling its work.
+func (c *Client) Shutdown(ctx context.Context, leave bool) {
+	c.log.Info("shutting down", "leave", leave)
+
+	if !leave {
+		c.flushAllocUpdates(ctx)
+		return
+	}
+
+	if drain := c.Config().DrainOnShutdown; drain.Enabled() {
+		c.selfDrain(ctx, drain)
+	}
+
+	// Stop watching for allocs so stopped allocs are not restarted
+	c.stopMu.Lock()
+	if c.stopWatch != nil {
+		c.stopWatch()
+	}
+	c.stopMu.Unlock()
+
+	c.stopAllocs(ctx)
+	c.flushAllocUpdates(ctx)
+
+	// Stop heartbeating before marking the node down so a heartbeat does not
+	// mark it ready again.
+	c.stopMu.Lock()
+	if c.stopHeartbeat != nil {
+		c.stopHeartbeat()
+	}
+	c.stopMu.Unlock()
+
+	if _, err := c.rpc.NodeUpdateStatus(ctx, structs.NodeStatusDown); err != nil {
+		c.log.Error("error marking node down", "error", err)
+		return
+	}
+	c.log.Info("marked node down")
+}
+
+// selfDrain marks the node ineligible and drains it, waiting until all allocs
+// have been stopped by the servers or the deadline is reached.
+func (c *Client) selfDrain(ctx context.Context, conf structs.DrainConfig) {
+	spec := structs.DrainSpec{
+		Deadline:         conf.Deadline,
+		IgnoreSystemJobs: conf.IgnoreSystemJobs,
+	}
+	if err := c.rpc.NodeUpdateDrain(ctx, spec); err != nil {
+		c.log.Error("error draining node; stopping allocs without draining", "error", err)
+		return
+	}
+	c.log.Info("draining node", "deadline", conf.Deadline)
+
+	ctx, cancel := context.WithTimeout(ctx, conf.Deadline)
+	defer cancel()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		n := len(c.runningAllocs())
+		if n == 0 {
+			c.log.Info("drain complete")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			c.log.Warn("drain deadline reached; stopping remaining allocs", "allocs", n)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopAllocs stops all running allocs and waits for them to exit or ctx to be
+// done.
+func (c *Client) stopAllocs(ctx context.Context) {
+	running := c.runningAllocs()
+	for _, ar := range running {
+		ar.Stop()
+	}
+	for _, ar := range running {
+		select {
+		case <-ar.WaitCh():
+		case <-ctx.Done():
+			c.log.Warn("timed out waiting for allocs to stop")
+			return
+		}
+	}
+	c.log.Info("stopped allocs", "allocs", len(running))
+}
+
+// runningAllocs returns the alloc runners that have not exited.
+func (c *Client) runningAllocs() []*allocrunner.AllocRunner {
+	c.allocsMu.RLock()
+	defer c.allocsMu.RUnlock()
+
+	var running []*allocrunner.AllocRunner
+	for _, ar := range c.allocs {
+		select {
+		case <-ar.WaitCh():
+		default:
+			running = append(running, ar)
+		}
+	}
+	return running
+}
+
+// flushAllocUpdates sends any pending alloc updates, retrying until they are
+// sent or ctx is done.
+func (c *Client) flushAllocUpdates(ctx context.Context) {
+	for {
+		n, err := c.syncAllocs(ctx)
+		if err == nil {
+			if n > 0 {
+				c.log.Info("sent final alloc updates", "allocs", n)
+			}
+			return
+		}
+
+		c.log.Error("error sending final alloc updates", "error", err, "allocs", n)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(allocSyncInterval):
+		}
+	}
+}
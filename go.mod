@@ -2,4 +2,9 @@ module github.com/schmichael/nomadlet
 
 go 1.24.2
 
-require github.com/ugorji/go/codec v1.2.12 // indirect
+require (
+	github.com/hashicorp/yamux v0.1.2
+	github.com/ugorji/go/codec v1.2.12
+)
+
+require github.com/hashicorp/hcl v1.0.0
@@ -0,0 +1,463 @@
+// Package config loads agent configuration files.
+//
+// Files may be HCL or JSON and use the same layout as Nomad agent
+// configuration files for the settings nomadlet supports:
+//
+//	region     = "global"
+//	datacenter = "dc1"
+//
+//	client {
+//	  servers = ["10.0.0.1:4647"]
+//
+//	  meta {
+//	    rack = "r1"
+//	  }
+//
+//	  host_network "public" {
+//	    cidr = "203.0.113.0/24"
+//	  }
+//
+//	  host_volume "data" {
+//	    path      = "/srv/data"
+//	    read_only = true
+//	  }
+//	}
+//
+//	plugin "raw_exec" {
+//	  config {
+//	    enabled = true
+//	  }
+//	}
+//
+//	tls {
+//	  ca_file = "nomad-ca.pem"
+//	}
+//
+//	addresses {
+//	  http = "127.0.0.1"
+//	}
+//
+//	ports {
+//	  http = 4656
+//	}
+//
+// Settings that are not set in a file do not override defaults or settings
+// from earlier files.
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// File is the contents of a configuration file.
+type File struct {
+	Region           *string `hcl:"region"`
+	Datacenter       *string `hcl:"datacenter"`
+	Name             *string `hcl:"name"`
+	LogLevel         *string `hcl:"log_level"`
+	LeaveOnInterrupt *bool   `hcl:"leave_on_interrupt"`
+	LeaveOnTerminate *bool   `hcl:"leave_on_terminate"`
+
+	Client    *Client            `hcl:"client"`
+	Plugins   map[string]*Plugin `hcl:"plugin"`
+	TLS       *TLS               `hcl:"tls"`
+	Addresses *Addresses         `hcl:"addresses"`
+	Ports     *Ports             `hcl:"ports"`
+}
+
+type Client struct {
+	Servers         []string `hcl:"servers"`
+	StateFile       *string  `hcl:"state_file"`
+	AllocDir        *string  `hcl:"alloc_dir"`
+	CPUCores        *int     `hcl:"cpu_cores"`
+	CPUTotalCompute *int     `hcl:"cpu_total_compute"`
+	MemoryTotalMB   *int     `hcl:"memory_total_mb"`
+
+	Reserved *Reserved `hcl:"reserved"`
+
+	NetworkInterface *string                 `hcl:"network_interface"`
+	NetworkSpeed     *int                    `hcl:"network_speed"`
+	HostNetworks     map[string]*HostNetwork `hcl:"host_network"`
+
+	HostVolumes         map[string]*HostVolume `hcl:"host_volume"`
+	HostVolumesDir      *string                `hcl:"host_volumes_dir"`
+	HostVolumePluginDir *string                `hcl:"host_volume_plugin_dir"`
+
+	Meta      map[string]string `hcl:"meta"`
+	NodeClass *string           `hcl:"node_class"`
+	NodePool  *string           `hcl:"node_pool"`
+
+	DrainOnShutdown *DrainOnShutdown `hcl:"drain_on_shutdown"`
+
+	StopAfterClientDisconnect *time.Duration `hcl:"stop_after_client_disconnect"`
+
+	GCInterval            *time.Duration `hcl:"gc_interval"`
+	GCMaxAllocs           *int           `hcl:"gc_max_allocs"`
+	GCDiskUsageThreshold  *float64       `hcl:"gc_disk_usage_threshold"`
+	GCInodeUsageThreshold *float64       `hcl:"gc_inode_usage_threshold"`
+	GCMaxAge              *time.Duration `hcl:"gc_max_age"`
+}
+
+// Reserved is resources reserved for the host.
+type Reserved struct {
+	CPU           *int    `hcl:"cpu"`
+	Cores         *string `hcl:"cores"`
+	Memory        *int    `hcl:"memory"`
+	Disk          *int    `hcl:"disk"`
+	ReservedPorts *string `hcl:"reserved_ports"`
+}
+
+// HostNetwork is a labeled block naming the host network.
+type HostNetwork struct {
+	CIDR          *string `hcl:"cidr"`
+	Interface     *string `hcl:"interface"`
+	ReservedPorts *string `hcl:"reserved_ports"`
+}
+
+// HostVolume is a labeled block naming the host volume.
+type HostVolume struct {
+	Path     *string `hcl:"path"`
+	ReadOnly *bool   `hcl:"read_only"`
+}
+
+// Plugin is a labeled block naming the task driver it configures. The
+// driver's config is validated when the client creates its drivers.
+type Plugin struct {
+	Config map[string]any `hcl:"config"`
+}
+
+// Addresses are the addresses nomadlet's listeners bind to.
+type Addresses struct {
+	HTTP *string `hcl:"http"`
+}
+
+// Ports are the ports nomadlet listens on. Unlike Nomad agents, nomadlet does
+// not serve HTTP unless its port is set.
+type Ports struct {
+	HTTP *int `hcl:"http"`
+}
+
+type DrainOnShutdown struct {
+	Deadline         *time.Duration `hcl:"deadline"`
+	IgnoreSystemJobs *bool          `hcl:"ignore_system_jobs"`
+}
+
+type TLS struct {
+	CAFile               *string `hcl:"ca_file"`
+	CertFile             *string `hcl:"cert_file"`
+	KeyFile              *string `hcl:"key_file"`
+	VerifyServerHostname *bool   `hcl:"verify_server_hostname"`
+}
+
+// extensions are the file extensions loaded from config directories.
+var extensions = []string{".hcl", ".json"}
+
+// Load loads the config files at paths, in order, into config. Directories
+// load their .hcl and .json files in lexical order. Later files override
+// earlier ones.
+func Load(config *structs.Config, paths ...string) error {
+	for _, path := range paths {
+		files, err := expand(path)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			f, err := ParseFile(file)
+			if err != nil {
+				return err
+			}
+			if err := f.Apply(config); err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expand returns the config files in path, which may be a file or directory.
+func expand(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config: %w", err)
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("error loading config dir: %w", err)
+	}
+
+	// ReadDir sorts entries by name
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() || !slices.Contains(extensions, strings.ToLower(filepath.Ext(e.Name()))) {
+			continue
+		}
+		files = append(files, filepath.Join(path, e.Name()))
+	}
+	return files, nil
+}
+
+// ParseFile parses an HCL or JSON config file.
+func ParseFile(path string) (*File, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+
+	f := &File{}
+	if err := decode(path, buf, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Apply overrides config with the settings set in the file after validating
+// them.
+func (f *File) Apply(config *structs.Config) error {
+	set(&config.Region, f.Region)
+	set(&config.Datacenter, f.Datacenter)
+	set(&config.Name, f.Name)
+	if f.LogLevel != nil {
+		if _, err := structs.ParseLogLevel(*f.LogLevel); err != nil {
+			return fmt.Errorf("log_level: %w", err)
+		}
+		config.LogLevel = *f.LogLevel
+	}
+	set(&config.LeaveOnInterrupt, f.LeaveOnInterrupt)
+	set(&config.LeaveOnTerminate, f.LeaveOnTerminate)
+
+	if c := f.Client; c != nil {
+		for i, s := range c.Servers {
+			if strings.TrimSpace(s) == "" {
+				return fmt.Errorf("client.servers[%d]: must not be empty", i)
+			}
+		}
+		if len(c.Servers) > 0 {
+			config.Servers = c.Servers
+		}
+		set(&config.StatePath, c.StateFile)
+		set(&config.AllocDir, c.AllocDir)
+		set(&config.Cores, c.CPUCores)
+		set(&config.Mhz, c.CPUTotalCompute)
+		set(&config.Mem, c.MemoryTotalMB)
+
+		if r := c.Reserved; r != nil {
+			if err := r.apply(&config.Reserved); err != nil {
+				return err
+			}
+		}
+
+		set(&config.NetworkInterface, c.NetworkInterface)
+		if err := nonNegative("client.network_speed", c.NetworkSpeed); err != nil {
+			return err
+		}
+		set(&config.NetworkSpeed, c.NetworkSpeed)
+		for _, name := range slices.Sorted(maps.Keys(c.HostNetworks)) {
+			hn, err := c.HostNetworks[name].hostNetwork(name)
+			if err != nil {
+				return err
+			}
+			if config.HostNetworks == nil {
+				config.HostNetworks = map[string]*structs.HostNetwork{}
+			}
+			config.HostNetworks[name] = hn
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.HostVolumes)) {
+			hv, err := c.HostVolumes[name].hostVolume(name)
+			if err != nil {
+				return err
+			}
+			if config.HostVolumes == nil {
+				config.HostVolumes = map[string]*structs.HostVolume{}
+			}
+			config.HostVolumes[name] = hv
+		}
+		set(&config.HostVolumesDir, c.HostVolumesDir)
+		set(&config.HostVolumePluginDir, c.HostVolumePluginDir)
+
+		// Meta is merged with meta from earlier files
+		for k, v := range c.Meta {
+			if k == "" {
+				return fmt.Errorf("client.meta: keys must not be empty")
+			}
+			if config.Meta == nil {
+				config.Meta = map[string]string{}
+			}
+			config.Meta[k] = v
+		}
+		set(&config.NodeClass, c.NodeClass)
+		if c.NodePool != nil {
+			if err := structs.ValidateNodePool(*c.NodePool); err != nil {
+				return fmt.Errorf("client.node_pool: %w", err)
+			}
+			config.NodePool = *c.NodePool
+		}
+
+		if d := c.DrainOnShutdown; d != nil {
+			set(&config.DrainOnShutdown.Deadline, d.Deadline)
+			set(&config.DrainOnShutdown.IgnoreSystemJobs, d.IgnoreSystemJobs)
+		}
+
+		set(&config.StopAfterClientDisconnect, c.StopAfterClientDisconnect)
+
+		if err := percent("client.gc_disk_usage_threshold", c.GCDiskUsageThreshold); err != nil {
+			return err
+		}
+		if err := percent("client.gc_inode_usage_threshold", c.GCInodeUsageThreshold); err != nil {
+			return err
+		}
+		set(&config.GC.Interval, c.GCInterval)
+		set(&config.GC.MaxAllocs, c.GCMaxAllocs)
+		set(&config.GC.DiskUsageThreshold, c.GCDiskUsageThreshold)
+		set(&config.GC.InodeUsageThreshold, c.GCInodeUsageThreshold)
+		set(&config.GC.MaxAge, c.GCMaxAge)
+	}
+
+	// Plugin configs are merged with configs from earlier files
+	for _, name := range slices.Sorted(maps.Keys(f.Plugins)) {
+		p := f.Plugins[name]
+		if config.Plugins == nil {
+			config.Plugins = map[string]map[string]any{}
+		}
+		if config.Plugins[name] == nil {
+			config.Plugins[name] = map[string]any{}
+		}
+		maps.Copy(config.Plugins[name], p.Config)
+	}
+
+	if t := f.TLS; t != nil {
+		set(&config.TLS.CAFile, t.CAFile)
+		set(&config.TLS.CertFile, t.CertFile)
+		set(&config.TLS.KeyFile, t.KeyFile)
+		set(&config.TLS.VerifyServerHostname, t.VerifyServerHostname)
+	}
+
+	if a := f.Addresses; a != nil {
+		set(&config.HTTPAddr, a.HTTP)
+	}
+	if p := f.Ports; p != nil {
+		if p.HTTP != nil && (*p.HTTP < 0 || *p.HTTP > 65535) {
+			return fmt.Errorf("ports.http: must be between 0 and 65535")
+		}
+		set(&config.HTTPPort, p.HTTP)
+	}
+
+	return nil
+}
+
+// apply validates the reserved resources and sets them in config.
+func (r *Reserved) apply(config *structs.ReservedConfig) error {
+	if err := nonNegative("client.reserved.cpu", r.CPU); err != nil {
+		return err
+	}
+	if err := nonNegative("client.reserved.memory", r.Memory); err != nil {
+		return err
+	}
+	if err := nonNegative("client.reserved.disk", r.Disk); err != nil {
+		return err
+	}
+	if r.Cores != nil {
+		ids, err := structs.ParseCPUList(*r.Cores)
+		if err != nil {
+			return fmt.Errorf("client.reserved.cores: %w", err)
+		}
+		config.Cores = nil
+		for _, id := range ids {
+			config.Cores = append(config.Cores, uint16(id))
+		}
+	}
+	if r.ReservedPorts != nil {
+		if _, err := structs.ParsePortRanges(*r.ReservedPorts); err != nil {
+			return fmt.Errorf("client.reserved.reserved_ports: %w", err)
+		}
+		config.Ports = *r.ReservedPorts
+	}
+	set(&config.CPU, r.CPU)
+	set(&config.MemoryMB, r.Memory)
+	set(&config.DiskMB, r.Disk)
+	return nil
+}
+
+// hostNetwork validates the named host network.
+func (h *HostNetwork) hostNetwork(name string) (*structs.HostNetwork, error) {
+	key := fmt.Sprintf("client.host_network.%s", name)
+	hn := &structs.HostNetwork{}
+	set(&hn.CIDR, h.CIDR)
+	set(&hn.Interface, h.Interface)
+	set(&hn.ReservedPorts, h.ReservedPorts)
+
+	if hn.CIDR == "" && hn.Interface == "" {
+		return nil, fmt.Errorf("%s: cidr or interface must be set", key)
+	}
+	if hn.CIDR != "" {
+		if _, _, err := net.ParseCIDR(hn.CIDR); err != nil {
+			return nil, fmt.Errorf("%s.cidr: %w", key, err)
+		}
+	}
+	if hn.ReservedPorts != "" {
+		if _, err := structs.ParsePortRanges(hn.ReservedPorts); err != nil {
+			return nil, fmt.Errorf("%s.reserved_ports: %w", key, err)
+		}
+	}
+	return hn, nil
+}
+
+// hostVolume validates the named host volume. The path must be an existing
+// directory.
+func (h *HostVolume) hostVolume(name string) (*structs.HostVolume, error) {
+	key := fmt.Sprintf("client.host_volume.%s", name)
+	hv := &structs.HostVolume{}
+	set(&hv.Path, h.Path)
+	set(&hv.ReadOnly, h.ReadOnly)
+
+	if hv.Path == "" {
+		return nil, fmt.Errorf("%s.path: must be set", key)
+	}
+	if !filepath.IsAbs(hv.Path) {
+		return nil, fmt.Errorf("%s.path: must be absolute", key)
+	}
+	hv.Path = filepath.Clean(hv.Path)
+	fi, err := os.Stat(hv.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s.path: %w", key, err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s.path: %s is not a directory", key, hv.Path)
+	}
+	return hv, nil
+}
+
+// percent validates that v, if set, is a percentage.
+func percent(key string, v *float64) error {
+	if v != nil && (*v < 0 || *v > 100) {
+		return fmt.Errorf("%s: must be between 0 and 100", key)
+	}
+	return nil
+}
+
+// nonNegative validates that v, if set, is not negative.
+func nonNegative(key string, v *int) error {
+	if v != nil && *v < 0 {
+		return fmt.Errorf("%s: must not be negative", key)
+	}
+	return nil
+}
+
+// set sets dst to v if v is set.
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
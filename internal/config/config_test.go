@@ -0,0 +1,149 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// writeFile writes a config file to dir and returns its path.
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+	return path
+}
+
+func TestLoad_MergeOrder(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "a.hcl", `
+datacenter = "dc1"
+
+client {
+  servers   = ["10.0.0.1:4647"]
+  node_pool = "a"
+
+  meta {
+    rack = "r1"
+    zone = "z1"
+  }
+}
+
+plugin "raw_exec" {
+  config {
+    enabled = false
+  }
+}
+`)
+	writeFile(t, dir, "b.json", `{
+  "datacenter": "dc2",
+  "client": {
+    "meta": {"rack": "r2"}
+  },
+  "plugin": {
+    "raw_exec": {
+      "config": {"enabled": true}
+    }
+  }
+}`)
+	// Files with other extensions are ignored
+	writeFile(t, dir, "c.txt", `datacenter = "ignored"`)
+	override := writeFile(t, t.TempDir(), "override.hcl", `
+client {
+  node_pool = "b"
+}
+`)
+
+	config := structs.DefaultConfig()
+	if err := Load(config, dir, override); err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+
+	if config.Datacenter != "dc2" {
+		t.Errorf("expected later file to set datacenter dc2, got %q", config.Datacenter)
+	}
+	if config.NodePool != "b" {
+		t.Errorf("expected later path to set node pool b, got %q", config.NodePool)
+	}
+	if len(config.Servers) != 1 || config.Servers[0] != "10.0.0.1:4647" {
+		t.Errorf("expected servers from earlier file to be kept, got %v", config.Servers)
+	}
+	if config.Meta["rack"] != "r2" || config.Meta["zone"] != "z1" {
+		t.Errorf("expected meta to be merged, got %v", config.Meta)
+	}
+	if enabled := config.Plugins["raw_exec"]["enabled"]; enabled != true {
+		t.Errorf("expected later file to enable raw_exec, got %v", enabled)
+	}
+	if config.Region != "global" {
+		t.Errorf("expected default region to be kept, got %q", config.Region)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{
+			name:     "unknown key",
+			contents: "client {\n  bogus = 1\n}\n",
+			err:      "2,3: client.bogus: unknown key",
+		},
+		{
+			name:     "wrong type",
+			contents: "client {\n  gc_max_allocs = \"many\"\n}\n",
+			err:      "2,19: client.gc_max_allocs: must be an integer",
+		},
+		{
+			name:     "invalid duration",
+			contents: "client {\n  gc_interval = \"soon\"\n}\n",
+			err:      "client.gc_interval: invalid duration \"soon\"",
+		},
+		{
+			name:     "invalid node pool",
+			contents: "client {\n  node_pool = \"a b\"\n}\n",
+			err:      "client.node_pool: invalid node pool \"a b\"",
+		},
+		{
+			name:     "relative host volume",
+			contents: "client {\n  host_volume \"data\" {\n    path = \"data\"\n  }\n}\n",
+			err:      "client.host_volume.data.path: must be absolute",
+		},
+		{
+			name:     "plugin without config block",
+			contents: "plugin \"raw_exec\" {\n  enabled = true\n}\n",
+			err:      "2,3: plugin.raw_exec.enabled: unknown key",
+		},
+		{
+			name:     "negative reserved cpu",
+			contents: "client {\n  reserved {\n    cpu = -1\n  }\n}\n",
+			err:      "client.reserved.cpu: must not be negative",
+		},
+		{
+			name:     "invalid http port",
+			contents: "ports {\n  http = 70000\n}\n",
+			err:      "ports.http: must be between 0 and 65535",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "config.hcl", tc.contents)
+			err := Load(structs.DefaultConfig(), path)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected error containing %q, got %q", tc.err, err)
+			}
+			if !strings.Contains(err.Error(), path) {
+				t.Errorf("expected error to name file %s, got %q", path, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/token"
+)
+
+var durationType = reflect.TypeFor[time.Duration]()
+
+// decode parses HCL or JSON into dst, a pointer to a struct whose fields are
+// tagged with their hcl keys. Unlike hcl.Decode, unknown keys are errors and
+// errors include the position and full key of the offending setting.
+func decode(filename string, buf []byte, dst any) error {
+	f, err := hcl.ParseBytes(buf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	list, ok := f.Node.(*ast.ObjectList)
+	if !ok {
+		return fmt.Errorf("%s: root must be an object", filename)
+	}
+
+	d := &decoder{filename: filename}
+	return d.object("", list, reflect.ValueOf(dst).Elem())
+}
+
+type decoder struct {
+	filename string
+}
+
+// errorf returns an error prefixed with the position and key of a setting.
+func (d *decoder) errorf(pos token.Pos, key string, format string, args ...any) error {
+	return fmt.Errorf("%s:%d,%d: %s: %s", d.filename, pos.Line, pos.Column, key, fmt.Sprintf(format, args...))
+}
+
+// object decodes the items of an HCL object into a struct.
+func (d *decoder) object(prefix string, list *ast.ObjectList, dst reflect.Value) error {
+	fields := map[string]reflect.Value{}
+	var keys []string
+	for i := range dst.NumField() {
+		tag := dst.Type().Field(i).Tag.Get("hcl")
+		if tag == "" {
+			continue
+		}
+		fields[tag] = dst.Field(i)
+		keys = append(keys, tag)
+	}
+
+	for _, item := range list.Items {
+		name := item.Keys[0].Token.Value().(string)
+		key := prefix + name
+		field, ok := fields[name]
+		if !ok {
+			return d.errorf(item.Pos(), key, "unknown key; must be one of: %s", strings.Join(keys, ", "))
+		}
+		if err := d.value(key, unflatten(item), field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// value decodes an HCL value into dst.
+func (d *decoder) value(key string, node ast.Node, dst reflect.Value) error {
+	if dst.Kind() == reflect.Pointer {
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		dst = dst.Elem()
+	}
+
+	switch dst.Kind() {
+	case reflect.Struct:
+		// Blocks are objects in HCL and may be a list of objects in JSON
+		objs, err := d.objects(key, node, "must be a block")
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if err := d.object(key+".", obj.List, dst); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Slice:
+		list, ok := node.(*ast.ListType)
+		if !ok {
+			return d.errorf(node.Pos(), key, "must be a list")
+		}
+		s := reflect.MakeSlice(dst.Type(), len(list.List), len(list.List))
+		for i, elem := range list.List {
+			if err := d.value(fmt.Sprintf("%s[%d]", key, i), elem, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+		return nil
+
+	case reflect.Map:
+		// Maps of blocks may also be a list of objects in JSON
+		objs, err := d.objects(key, node, "must be an object")
+		if err != nil {
+			return err
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.MakeMap(dst.Type()))
+		}
+		for _, obj := range objs {
+			for _, item := range obj.List.Items {
+				name := item.Keys[0].Token.Value().(string)
+				v := reflect.New(dst.Type().Elem()).Elem()
+				if err := d.value(key+"."+name, unflatten(item), v); err != nil {
+					return err
+				}
+				dst.SetMapIndex(reflect.ValueOf(name), v)
+			}
+		}
+		return nil
+
+	case reflect.Interface:
+		v, err := d.any(key, node)
+		if err != nil {
+			return err
+		}
+		dst.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	lit, ok := node.(*ast.LiteralType)
+	if !ok {
+		return d.errorf(node.Pos(), key, "must be a %s", typeName(dst.Type()))
+	}
+	v := lit.Token.Value()
+
+	switch {
+	case dst.Type() == durationType:
+		s, ok := v.(string)
+		if !ok {
+			return d.errorf(lit.Pos(), key, "must be a duration string such as \"30s\"")
+		}
+		dur, err := time.ParseDuration(s)
+		if err != nil {
+			return d.errorf(lit.Pos(), key, "invalid duration %q", s)
+		}
+		dst.SetInt(int64(dur))
+	case dst.Kind() == reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			return d.errorf(lit.Pos(), key, "must be a string")
+		}
+		dst.SetString(s)
+	case dst.Kind() == reflect.Bool:
+		b, ok := v.(bool)
+		if !ok {
+			return d.errorf(lit.Pos(), key, "must be true or false")
+		}
+		dst.SetBool(b)
+	case dst.Kind() == reflect.Int:
+		n, ok := v.(int64)
+		if !ok {
+			return d.errorf(lit.Pos(), key, "must be an integer")
+		}
+		dst.SetInt(n)
+	case dst.Kind() == reflect.Float64:
+		switch n := v.(type) {
+		case int64:
+			dst.SetFloat(float64(n))
+		case float64:
+			dst.SetFloat(n)
+		default:
+			return d.errorf(lit.Pos(), key, "must be a number")
+		}
+	default:
+		// Only reachable if a File field has an unsupported type
+		panic(fmt.Sprintf("unsupported config type %s for %s", dst.Type(), key))
+	}
+	return nil
+}
+
+// any decodes an HCL value whose type is not known ahead of time, such as a
+// plugin's config. Objects are decoded as map[string]any and lists as []any.
+func (d *decoder) any(key string, node ast.Node) (any, error) {
+	switch n := node.(type) {
+	case *ast.ObjectType:
+		m := map[string]any{}
+		for _, item := range n.List.Items {
+			name := item.Keys[0].Token.Value().(string)
+			v, err := d.any(key+"."+name, unflatten(item))
+			if err != nil {
+				return nil, err
+			}
+			m[name] = v
+		}
+		return m, nil
+	case *ast.ListType:
+		l := make([]any, len(n.List))
+		for i, elem := range n.List {
+			v, err := d.any(fmt.Sprintf("%s[%d]", key, i), elem)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = v
+		}
+		return l, nil
+	case *ast.LiteralType:
+		return n.Token.Value(), nil
+	default:
+		return nil, d.errorf(node.Pos(), key, "unsupported value")
+	}
+}
+
+// objects returns node as a list of objects. node may be an object or a list
+// of objects.
+func (d *decoder) objects(key string, node ast.Node, msg string) ([]*ast.ObjectType, error) {
+	switch n := node.(type) {
+	case *ast.ObjectType:
+		return []*ast.ObjectType{n}, nil
+	case *ast.ListType:
+		var objs []*ast.ObjectType
+		for _, elem := range n.List {
+			obj, ok := elem.(*ast.ObjectType)
+			if !ok {
+				return nil, d.errorf(elem.Pos(), key, "%s", msg)
+			}
+			objs = append(objs, obj)
+		}
+		return objs, nil
+	default:
+		return nil, d.errorf(node.Pos(), key, "%s", msg)
+	}
+}
+
+// unflatten returns the value of an item with multiple keys as nested objects
+// with one key each. Labeled blocks such as host_network "name" {} have a key
+// per label and the JSON parser flattens nested objects into multiple keys.
+func unflatten(item *ast.ObjectItem) ast.Node {
+	if len(item.Keys) == 1 {
+		return item.Val
+	}
+	return &ast.ObjectType{
+		Lbrace: item.Keys[1].Pos(),
+		List: &ast.ObjectList{
+			Items: []*ast.ObjectItem{{Keys: item.Keys[1:], Val: item.Val}},
+		},
+	}
+}
+
+// typeName describes a type in error messages.
+func typeName(t reflect.Type) string {
+	switch {
+	case t == durationType:
+		return "duration string"
+	case t.Kind() == reflect.Int, t.Kind() == reflect.Float64:
+		return "number"
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,80 @@
+package retry
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy is an exponential backoff policy with jitter.
+type Policy struct {
+	// Min is the delay after the first failure
+	Min time.Duration
+
+	// Max caps the delay
+	Max time.Duration
+
+	// Jitter is the fraction of each delay to randomly subtract so many
+	// clients failing at once do not retry in lockstep. Must be in [0, 1].
+	Jitter float64
+}
+
+var (
+	// Default is suitable for most RPCs.
+	Default = Policy{
+		Min:    1 * time.Second,
+		Max:    30 * time.Second,
+		Jitter: 0.25,
+	}
+
+	// Heartbeat retries quickly so the node is not marked down while there
+	// are still servers available to heartbeat with.
+	Heartbeat = Policy{
+		Min:    500 * time.Millisecond,
+		Max:    5 * time.Second,
+		Jitter: 0.25,
+	}
+)
+
+// Backoff tracks the consecutive failures of a single retry loop.
+type Backoff struct {
+	policy   Policy
+	attempts int
+}
+
+func (p Policy) Backoff() *Backoff {
+	return &Backoff{policy: p}
+}
+
+// Next records a failure and returns the delay before the next attempt.
+func (b *Backoff) Next() time.Duration {
+	delay := b.policy.Min
+	for i := 0; i < b.attempts && delay < b.policy.Max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, b.policy.Max)
+	b.attempts++
+
+	if b.policy.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * b.policy.Jitter * float64(delay))
+	}
+	return delay
+}
+
+// Reset is called after a success so the next failure starts from Min.
+func (b *Backoff) Reset() {
+	b.attempts = 0
+}
+
+// Wait records a failure and sleeps until the next attempt or ctx is done, in
+// which case ctx's error is returned.
+func (b *Backoff) Wait(ctx context.Context) error {
+	t := time.NewTimer(b.Next())
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
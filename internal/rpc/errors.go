@@ -0,0 +1,70 @@
+package rpc
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors returned by servers. Servers only send error strings, so these are
+// matched against ServerErrors with errors.Is.
+var (
+	ErrNoLeader           = errors.New("No cluster leader")
+	ErrPermissionDenied   = errors.New("Permission denied")
+	ErrNodeSecretMismatch = errors.New("node secret ID does not match")
+	ErrRateLimited        = errors.New("rate limit exceeded")
+	ErrNodeNotFound       = errors.New("node not found")
+)
+
+// serverErrors maps each known error to the substrings servers use for it.
+var serverErrors = []struct {
+	err     error
+	substrs []string
+}{
+	{ErrNoLeader, []string{"no cluster leader"}},
+	{ErrPermissionDenied, []string{"permission denied"}},
+	{ErrNodeSecretMismatch, []string{"node secret id does not match"}},
+	{ErrRateLimited, []string{"rate limit exceeded", "too many requests"}},
+	{ErrNodeNotFound, []string{"node not found", "unknown node"}},
+}
+
+// ServerError is an error returned by a server in an RPC response as opposed
+// to an error communicating with the server.
+type ServerError struct {
+	Method string
+	Msg    string
+}
+
+func (e *ServerError) Error() string {
+	return e.Msg
+}
+
+// Is returns true if target is one of the known server errors and matches
+// this error's message.
+func (e *ServerError) Is(target error) bool {
+	msg := strings.ToLower(e.Msg)
+	for _, se := range serverErrors {
+		if se.err != target {
+			continue
+		}
+		for _, substr := range se.substrs {
+			if strings.Contains(msg, substr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsRetryable returns true if the RPC may succeed if retried without any
+// changes. Errors communicating with servers are always retryable, as are
+// server errors caused by transient cluster conditions.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var srvErr *ServerError
+	if !errors.As(err, &srvErr) {
+		return true
+	}
+	return errors.Is(err, ErrNoLeader) || errors.Is(err, ErrRateLimited)
+}
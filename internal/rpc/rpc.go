@@ -1,205 +1,557 @@
 package rpc
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
+	"slices"
 	"sync"
+	"time"
 
+	"github.com/hashicorp/yamux"
 	"github.com/schmichael/nomadlet/internal/structs"
 	"github.com/ugorji/go/codec"
 )
 
 const (
-	// rpcMagicByte is the synchronous RpcNomad magic byte
+	// rpcMagicByte is the synchronous RpcNomad magic byte. It is written at
+	// the start of every stream opened on a multiplexed session.
 	rpcMagicByte byte = 0x01
+
+	// rpcMultiplexV2MagicByte is the RpcMultiplexV2 magic byte. It is written
+	// once per connection before starting a yamux session.
+	rpcMultiplexV2MagicByte byte = 0x06
+
+	// dialTimeout is the maximum time to spend connecting to a server.
+	dialTimeout = 10 * time.Second
+
+	// defaultRPCTimeout is the deadline for calls whose context does not
+	// already have one.
+	defaultRPCTimeout = 30 * time.Second
+
+	// blockingQueryJitterFraction mirrors the server, which adds up to
+	// MaxQueryTime/16 of jitter to blocking queries.
+	blockingQueryJitterFraction = 16
 )
 
 var (
-	msgpackHandle = &codec.MsgpackHandle{}
+	msgpackHandle = NewMsgpackHandle()
 )
 
-// Client is a synchronous RPC client safe to call from multiple goroutines.
+// NewMsgpackHandle returns a handle that, like the servers', decodes strings in
+// untyped values such as task config as strings instead of []byte.
+func NewMsgpackHandle() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.RawToString = true
+	return h
+}
+
+// Client is an RPC client safe to call from multiple goroutines. RPCs are
+// multiplexed over a single connection per server with each call using its
+// own stream, so a slow call does not block others.
 type Client struct {
 	region     string
+	datacenter string
+
+	// nodeID and nodeSecret identify the node to servers. Guarded by mu.
 	nodeID     string
 	nodeSecret string
-	addr       string
-	conn       net.Conn
-	seq        uint64
+
+	// seeds are the configured servers and servers is the current list of
+	// servers to try in order. addr is the server session is connected to.
+	seeds   []string
+	servers []string
+	addr    string
+	session *yamux.Session
+	seq     uint64
+
+	// dialing is the connection attempt in progress or nil. Callers needing
+	// a session wait for it instead of dialing themselves.
+	dialing *dialCall
+
+	// tls is nil if TLS is disabled
+	tls *tlsConfigurator
+
+	// server handles RPCs sent by servers over sessions. May be nil.
+	server *Server
 
 	mu sync.Mutex
 }
 
 func NewClient(state *structs.State, conf *structs.Config) (*Client, error) {
-	return &Client{
+	servers := mergeServers(conf.Servers, state.Servers)
+	if len(servers) == 0 {
+		return nil, errors.New("no servers configured")
+	}
+
+	c := &Client{
 		region:     conf.Region,
+		datacenter: conf.Datacenter,
 		nodeID:     state.NodeID,
 		nodeSecret: state.NodeSecret,
-		addr:       conf.Server,
-	}, nil
+		seeds:      mergeServers(conf.Servers),
+		servers:    servers,
+	}
+
+	if conf.TLS.Enabled() {
+		tlsConf, err := newTLSConfigurator(conf.TLS, conf.Region)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring tls: %w", err)
+		}
+		c.tls = tlsConf
+	}
+
+	return c, nil
+}
+
+// SetServer sets the server used to handle RPCs sent by Nomad servers over
+// sessions established by this client. Must be called before the first RPC.
+func (c *Client) SetServer(s *Server) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.server = s
 }
 
-func (c *Client) getConn() (net.Conn, error) {
-	if c.conn != nil {
-		return c.conn, nil
+// SetNode sets the node ID and secret sent with RPCs, such as after the node
+// registers under a new ID.
+func (c *Client) SetNode(id, secret string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nodeID = id
+	c.nodeSecret = secret
+}
+
+// credentials returns the node ID and secret sent with RPCs.
+func (c *Client) credentials() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodeID, c.nodeSecret
+}
+
+// dialCall is a connection attempt shared by every caller waiting for a
+// session. session and err are set before doneCh is closed.
+type dialCall struct {
+	doneCh  chan struct{}
+	session *yamux.Session
+	err     error
+}
+
+// getSession returns the current session or waits for a connection to a
+// server. Only one connection attempt is made at a time and it runs without
+// holding c.mu, so callers with a session are never blocked by reconnecting.
+func (c *Client) getSession(ctx context.Context) (*yamux.Session, error) {
+	c.mu.Lock()
+	if c.session != nil && !c.session.IsClosed() {
+		session := c.session
+		c.mu.Unlock()
+		return session, nil
 	}
-	tcpaddr, err := net.ResolveTCPAddr("tcp", c.addr)
-	if err != nil {
-		return nil, fmt.Errorf("error resolving server address: %w", c.addr)
+	c.session = nil
+	call := c.dialing
+	if call == nil {
+		call = &dialCall{doneCh: make(chan struct{})}
+		c.dialing = call
+		go c.connect(call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.doneCh:
+		return call.session, call.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting to connect to a server: %w", ctx.Err())
+	}
+}
+
+// connect dials the servers in order until one succeeds and installs its
+// session. Servers that fail to connect are rotated to the back of the list.
+// The attempt is not bound to any caller's context so callers that give up
+// do not fail it for the others.
+func (c *Client) connect(call *dialCall) {
+	defer close(call.doneCh)
+
+	c.mu.Lock()
+	servers := slices.Clone(c.servers)
+	tlsConf := c.tls
+	c.mu.Unlock()
+
+	var errs []error
+	for _, addr := range servers {
+		session, err := c.dial(addr, tlsConf)
+		if err != nil {
+			errs = append(errs, err)
+			c.mu.Lock()
+			if len(c.servers) > 0 && c.servers[0] == addr {
+				c.rotateServer()
+			}
+			c.mu.Unlock()
+			continue
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.dialing = nil
+		if c.tls != tlsConf {
+			// ReloadTLS was called while connecting
+			session.Close()
+			call.err = errors.New("tls configuration reloaded while connecting to server")
+			return
+		}
+		c.addr = addr
+		c.session = session
+		if c.server != nil {
+			go c.server.serveSession(session)
+		}
+		call.session = session
+		return
+	}
+
+	c.mu.Lock()
+	c.dialing = nil
+	c.mu.Unlock()
+	call.err = errors.Join(errs...)
+	if call.err == nil {
+		call.err = errors.New("no servers configured")
 	}
-	conn, err := net.DialTCP("tcp", nil, tcpaddr)
+}
+
+// dial connects to a server, wrapping the connection in TLS if tlsConf is not
+// nil, and starts a multiplexed session.
+func (c *Client) dial(addr string, tlsConf *tlsConfigurator) (*yamux.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	dialer := &net.Dialer{}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return nil, fmt.Errorf("error connecting to server: %w", err)
+		return nil, fmt.Errorf("error connecting to server %q: %w", addr, err)
 	}
+	conn := netConn.(*net.TCPConn)
 	if err := conn.SetKeepAlive(true); err != nil {
 		return nil, fmt.Errorf("error setting keepalives: %w", err)
 	}
 	if err := conn.SetNoDelay(true); err != nil {
 		return nil, fmt.Errorf("error setting no delay: %w", err)
 	}
-	if n, err := conn.Write([]byte{rpcMagicByte}); err != nil || n != 1 {
+
+	var rpcConn net.Conn = conn
+	if tlsConf != nil {
+		if n, err := conn.Write([]byte{rpcTLSMagicByte}); err != nil || n != 1 {
+			conn.Close()
+			return nil, fmt.Errorf("error writing tls magic byte: err=%w n=%d", err, n)
+		}
+		tlsConn := tls.Client(conn, tlsConf.Config())
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error performing tls handshake with %q: %w", addr, err)
+		}
+		rpcConn = tlsConn
+	}
+
+	if n, err := rpcConn.Write([]byte{rpcMultiplexV2MagicByte}); err != nil || n != 1 {
+		rpcConn.Close()
 		return nil, fmt.Errorf("error writing magic byte: err=%w n=%d", err, n)
 	}
-	c.conn = conn
-	return conn, nil
-}
 
-func (c *Client) closeConn() {
-	c.conn.Close()
-	c.conn = nil
+	yconf := yamux.DefaultConfig()
+	yconf.LogOutput = io.Discard
+	session, err := yamux.Client(rpcConn, yconf)
+	if err != nil {
+		rpcConn.Close()
+		return nil, fmt.Errorf("error starting session with %q: %w", addr, err)
+	}
+	return session, nil
 }
 
-func (c *Client) do(method string, request, response any) error {
+// closeSession closes the session and rotates to the next server. It is a
+// noop if the session has already been replaced by another caller.
+func (c *Client) closeSession(session *yamux.Session) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.session != session {
+		return
+	}
+	c.session.Close()
+	c.session = nil
+	c.rotateServer()
+}
+
+// openStream opens a new stream for a single RPC and writes the RpcNomad
+// magic byte.
+func (c *Client) openStream(ctx context.Context) (*yamux.Session, *yamux.Stream, uint64, error) {
+	session, err := c.getSession(ctx)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	c.mu.Lock()
 	c.seq++
-	reqHeader := &requestHeader{
-		ServiceMethod: method,
-		Seq:           c.seq,
+	seq := c.seq
+	c.mu.Unlock()
+
+	stream, err := session.OpenStream()
+	if err != nil {
+		c.closeSession(session)
+		return nil, nil, 0, fmt.Errorf("error opening stream: %w", err)
 	}
 
-	conn, err := c.getConn()
+	if _, err := stream.Write([]byte{rpcMagicByte}); err != nil {
+		stream.Close()
+		c.closeSession(session)
+		return nil, nil, 0, fmt.Errorf("error writing stream magic byte: %w", err)
+	}
+	return session, stream, seq, nil
+}
+
+// do performs a single RPC on its own stream. The stream's deadline is taken
+// from ctx, or defaultRPCTimeout if ctx has none. If ctx is cancelled during the
+// call only the stream is abandoned; the session remains usable by other calls.
+func (c *Client) do(ctx context.Context, method string, request, response any) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%q RPC not sent: %w", method, err)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRPCTimeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	session, stream, seq, err := c.openStream(ctx)
 	if err != nil {
 		return err
 	}
+	defer stream.Close()
+
+	if err := stream.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("error setting %q deadline: %w", method, err)
+	}
 
-	enc := codec.NewEncoder(conn, msgpackHandle)
+	// Unblock reads and writes as soon as ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		stream.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	reqHeader := &requestHeader{
+		ServiceMethod: method,
+		Seq:           seq,
+	}
+
+	enc := codec.NewEncoder(stream, msgpackHandle)
 
 	if err := enc.Encode(reqHeader); err != nil {
-		c.closeConn()
-		return fmt.Errorf("error writing %q request header: %w",
-			method, err)
+		return c.streamFailed(ctx, session, fmt.Errorf("error writing %q request header: %w",
+			method, err))
 	}
 
 	if err := enc.Encode(request); err != nil {
-		c.closeConn()
-		return fmt.Errorf("error writing %q request body: %w",
-			method, err)
+		return c.streamFailed(ctx, session, fmt.Errorf("error writing %q request body: %w",
+			method, err))
 	}
 
 	// Read resposne
-	dec := codec.NewDecoder(conn, msgpackHandle)
+	dec := codec.NewDecoder(stream, msgpackHandle)
 	respHeader := &responseHeader{}
 	if err := dec.Decode(respHeader); err != nil {
-		c.closeConn()
-		return fmt.Errorf("error writing %q request body: %w",
-			method, err)
+		return c.streamFailed(ctx, session, fmt.Errorf("error reading %q response header: %w",
+			method, err))
 	}
 
 	if respHeader.Error != "" {
 		// Throw away body and return error
 		if err := dec.Decode(&struct{}{}); err != nil {
-			c.closeConn()
-			return fmt.Errorf("error discarding response body: %w - after %q RPC returned an error: %s", err, method, respHeader.Error)
+			return c.streamFailed(ctx, session, fmt.Errorf("error discarding response body: %w - after %q RPC returned an error: %s", err, method, respHeader.Error))
+		}
+		srvErr := &ServerError{Method: method, Msg: respHeader.Error}
+		if errors.Is(srvErr, ErrNoLeader) {
+			// Another server may still be able to service the request so
+			// try it on the next request.
+			c.closeSession(session)
 		}
-		return errors.New(respHeader.Error)
+		return srvErr
 	}
 
 	// No body for Status.Ping
 	if err := dec.Decode(response); err != nil {
-		c.closeConn()
-		return fmt.Errorf("error reading %q response body: %w", method, err)
+		return c.streamFailed(ctx, session, fmt.Errorf("error reading %q response body: %w", method, err))
 	}
 
 	return nil
 }
 
-func (c *Client) StatusPing() error {
+// streamFailed handles an error reading or writing a stream. If ctx is done
+// the caller gave up and the stream is simply abandoned. Otherwise the session
+// is assumed to be broken and is closed so the next call reconnects.
+func (c *Client) streamFailed(ctx context.Context, session *yamux.Session, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	if deadline, _ := ctx.Deadline(); !time.Now().Before(deadline) {
+		// The stream's deadline, which is ctx's, may be reached just before
+		// ctx is done
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	c.closeSession(session)
+	return err
+}
+
+func (c *Client) StatusPing(ctx context.Context) error {
+	_, secret := c.credentials()
 	req := &queryRequest{
 		Region:    c.region,
-		AuthToken: c.nodeSecret,
+		AuthToken: secret,
 	}
 
-	return c.do("Status.Ping", req, &struct{}{})
+	return c.do(ctx, "Status.Ping", req, &struct{}{})
 }
 
-func (c *Client) NodeRegister(node *structs.Node) (*NodeUpdateResponse, error) {
-	req := &nodeRegisterRequest{
+func (c *Client) NodeRegister(ctx context.Context, node *structs.Node) (*NodeUpdateResponse, error) {
+	_, secret := c.credentials()
+	req := &NodeRegisterRequest{
 		Node: node,
 		WriteRequest: WriteRequest{
 			Region:    c.region,
-			AuthToken: c.nodeSecret,
+			AuthToken: secret,
 		},
 	}
 
 	resp := &NodeUpdateResponse{}
-	if err := c.do("Node.Register", req, resp); err != nil {
+	if err := c.do(ctx, "Node.Register", req, resp); err != nil {
 		return nil, err
 	}
 
 	return resp, nil
 }
 
-func (c *Client) NodeUpdateStatus() (*NodeUpdateResponse, error) {
+// NodeUpdateStatus heartbeats with the given node status.
+func (c *Client) NodeUpdateStatus(ctx context.Context, status string) (*NodeUpdateResponse, error) {
+	nodeID, secret := c.credentials()
 	req := &NodeUpdateStatusRequest{
-		NodeID: c.nodeID,
-		Status: "ready",
+		NodeID: nodeID,
+		Status: status,
 
 		WriteRequest: WriteRequest{
 			Region:    c.region,
-			AuthToken: c.nodeSecret,
+			AuthToken: secret,
 		},
 	}
 
 	resp := &NodeUpdateResponse{}
-	if err := c.do("Node.UpdateStatus", req, resp); err != nil {
+	if err := c.do(ctx, "Node.UpdateStatus", req, resp); err != nil {
 		return nil, err
 	}
 
 	return resp, nil
 }
 
-func (c *Client) NodeGetClientAllocs() (*NodeClientAllocsResponse, error) {
-	req := &NodeSpecificRequest{
-		NodeID:   c.nodeID,
-		SecretID: c.nodeSecret,
+// NodeUpdateDrain starts draining this node, which also marks it ineligible
+// for scheduling.
+func (c *Client) NodeUpdateDrain(ctx context.Context, spec structs.DrainSpec) error {
+	nodeID, secret := c.credentials()
+	now := time.Now()
+	req := &NodeUpdateDrainRequest{
+		NodeID: nodeID,
+		DrainStrategy: &structs.DrainStrategy{
+			DrainSpec:     spec,
+			ForceDeadline: now.Add(spec.Deadline),
+			StartedAt:     now,
+		},
+		Meta:      map[string]string{"message": "shutdown"},
+		UpdatedAt: now.Unix(),
 		WriteRequest: WriteRequest{
 			Region:    c.region,
-			AuthToken: c.nodeSecret,
+			AuthToken: secret,
 		},
 	}
 
+	return c.do(ctx, "Node.UpdateDrain", req, &NodeDrainUpdateResponse{})
+}
+
+// NodeEmitEvents adds events to this node's history.
+func (c *Client) NodeEmitEvents(ctx context.Context, events []*structs.NodeEvent) error {
+	nodeID, secret := c.credentials()
+	req := &EmitNodeEventsRequest{
+		NodeEvents: map[string][]*structs.NodeEvent{nodeID: events},
+		WriteRequest: WriteRequest{
+			Region:    c.region,
+			AuthToken: secret,
+		},
+	}
+
+	return c.do(ctx, "Node.EmitEvents", req, &EmitNodeEventsResponse{})
+}
+
+// NodeGetNode returns this node as known by the servers, including its drain
+// strategy.
+func (c *Client) NodeGetNode(ctx context.Context) (*structs.Node, error) {
+	nodeID, secret := c.credentials()
+	req := &NodeSpecificRequest{
+		NodeID:   nodeID,
+		SecretID: secret,
+		QueryOptions: QueryOptions{
+			Region:     c.region,
+			AuthToken:  secret,
+			AllowStale: true,
+		},
+	}
+
+	resp := &SingleNodeResponse{}
+	if err := c.do(ctx, "Node.GetNode", req, resp); err != nil {
+		return nil, err
+	}
+	if resp.Node == nil {
+		return nil, fmt.Errorf("node %q not found", nodeID)
+	}
+
+	return resp.Node, nil
+}
+
+// NodeGetClientAllocs returns the allocations for this node. The call blocks
+// until the allocations change after minIndex or maxWait elapses. A minIndex of
+// 0 returns immediately.
+func (c *Client) NodeGetClientAllocs(ctx context.Context, minIndex uint64, maxWait time.Duration) (*NodeClientAllocsResponse, error) {
+	nodeID, secret := c.credentials()
+	req := &NodeSpecificRequest{
+		NodeID:   nodeID,
+		SecretID: secret,
+		QueryOptions: QueryOptions{
+			Region:        c.region,
+			AuthToken:     secret,
+			MinQueryIndex: minIndex,
+			MaxQueryTime:  maxWait,
+			AllowStale:    true,
+		},
+	}
+
+	// Allow for the server holding the query open for maxWait plus jitter
+	timeout := maxWait + maxWait/blockingQueryJitterFraction + defaultRPCTimeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	resp := &NodeClientAllocsResponse{}
-	if err := c.do("Node.GetClientAllocs", req, resp); err != nil {
+	if err := c.do(ctx, "Node.GetClientAllocs", req, resp); err != nil {
 		return nil, err
 	}
 
 	return resp, nil
 }
 
-func (c *Client) GetAlloc(id string) (*structs.Allocation, error) {
+func (c *Client) GetAlloc(ctx context.Context, id string) (*structs.Allocation, error) {
+	_, secret := c.credentials()
 	// Use GetAllocs RPC since we don't know the namespace
 	req := &AllocsGetRequest{
 		AllocIDs: []string{id},
 		QueryOptions: QueryOptions{
 			Region:    c.region,
-			AuthToken: c.nodeSecret,
+			AuthToken: secret,
 		},
 	}
 
 	resp := &AllocsGetResponse{}
-	if err := c.do("Alloc.GetAllocs", req, resp); err != nil {
+	if err := c.do(ctx, "Alloc.GetAllocs", req, resp); err != nil {
 		return nil, err
 	}
 
@@ -209,3 +561,18 @@ func (c *Client) GetAlloc(id string) (*structs.Allocation, error) {
 
 	return resp.Allocs[0], nil
 }
+
+// NodeUpdateAlloc sends client-side allocation status updates to servers.
+// Allocations must have their NodeID set.
+func (c *Client) NodeUpdateAlloc(ctx context.Context, allocs []*structs.Allocation) error {
+	_, secret := c.credentials()
+	req := &AllocUpdateRequest{
+		Alloc: allocs,
+		WriteRequest: WriteRequest{
+			Region:    c.region,
+			AuthToken: secret,
+		},
+	}
+
+	return c.do(ctx, "Node.UpdateAlloc", req, &GenericResponse{})
+}
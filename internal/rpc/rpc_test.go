@@ -0,0 +1,344 @@
+package rpc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/rpc/rpctest"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// newTestClient starts a fake server and returns a client of it and the node
+// to register.
+func newTestClient(t *testing.T) (*rpctest.Server, *rpc.Client, *structs.Node) {
+	t.Helper()
+
+	srv, err := rpctest.NewServer()
+	if err != nil {
+		t.Fatalf("error starting server: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	state := &structs.State{NodeID: "node-1", NodeSecret: "secret-1"}
+	config := structs.DefaultConfig()
+	config.Servers = []string{srv.Addr}
+	c, err := rpc.NewClient(state, config)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	node, err := structs.MakeNode(state, config)
+	if err != nil {
+		t.Fatalf("error making node: %v", err)
+	}
+	return srv, c, node
+}
+
+// register registers node and marks it ready.
+func register(t *testing.T, c *rpc.Client, node *structs.Node) *rpc.NodeUpdateResponse {
+	t.Helper()
+	if _, err := c.NodeRegister(context.Background(), node); err != nil {
+		t.Fatalf("error registering: %v", err)
+	}
+	resp, err := c.NodeUpdateStatus(context.Background(), structs.NodeStatusReady)
+	if err != nil {
+		t.Fatalf("error heartbeating: %v", err)
+	}
+	return resp
+}
+
+func TestClient_RegisterHeartbeat(t *testing.T) {
+	srv, c, node := newTestClient(t)
+	srv.SetHeartbeatTTL(3 * time.Second)
+
+	resp := register(t, c, node)
+	if resp.HeartbeatTTL != 3*time.Second {
+		t.Errorf("expected heartbeat ttl 3s, got %s", resp.HeartbeatTTL)
+	}
+	if len(resp.Servers) != 1 || resp.Servers[0].RPCAdvertiseAddr != srv.Addr {
+		t.Errorf("expected server list of %s, got %+v", srv.Addr, resp.Servers)
+	}
+
+	got := srv.Node(node.ID)
+	if got == nil {
+		t.Fatalf("node not registered")
+	}
+	if got.Status != structs.NodeStatusReady {
+		t.Errorf("expected status %q, got %q", structs.NodeStatusReady, got.Status)
+	}
+	if got.Name != node.Name {
+		t.Errorf("expected name %q, got %q", node.Name, got.Name)
+	}
+}
+
+func TestClient_HeartbeatUnknownNode(t *testing.T) {
+	_, c, _ := newTestClient(t)
+
+	_, err := c.NodeUpdateStatus(context.Background(), structs.NodeStatusReady)
+	if !errors.Is(err, rpc.ErrNodeNotFound) {
+		t.Fatalf("expected node not found, got %v", err)
+	}
+	if rpc.IsRetryable(err) {
+		t.Errorf("expected node not found to not be retryable")
+	}
+}
+
+func TestClient_GetClientAllocsBlocking(t *testing.T) {
+	srv, c, node := newTestClient(t)
+	register(t, c, node)
+
+	resp, err := c.NodeGetClientAllocs(context.Background(), 0, time.Second)
+	if err != nil {
+		t.Fatalf("error getting allocs: %v", err)
+	}
+	if len(resp.Allocs) != 0 {
+		t.Fatalf("expected no allocs, got %v", resp.Allocs)
+	}
+
+	type result struct {
+		resp *rpc.NodeClientAllocsResponse
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		resp, err := c.NodeGetClientAllocs(context.Background(), resp.Index, 10*time.Second)
+		resultCh <- result{resp, err}
+	}()
+
+	// The query must block until the alloc is added
+	select {
+	case r := <-resultCh:
+		t.Fatalf("expected query to block, returned %+v %v", r.resp, r.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	srv.UpsertAlloc(&structs.Allocation{ID: "alloc-1", NodeID: node.ID})
+
+	select {
+	case r := <-resultCh:
+		if r.err != nil {
+			t.Fatalf("error getting allocs: %v", r.err)
+		}
+		if _, ok := r.resp.Allocs["alloc-1"]; !ok {
+			t.Errorf("expected alloc-1, got %v", r.resp.Allocs)
+		}
+		if r.resp.Index <= resp.Index {
+			t.Errorf("expected index greater than %d, got %d", resp.Index, r.resp.Index)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("query not woken after %s", time.Since(start))
+	}
+}
+
+func TestClient_UpdateAlloc(t *testing.T) {
+	srv, c, node := newTestClient(t)
+	register(t, c, node)
+	srv.UpsertAlloc(&structs.Allocation{ID: "alloc-1", NodeID: node.ID})
+
+	alloc, err := c.GetAlloc(context.Background(), "alloc-1")
+	if err != nil {
+		t.Fatalf("error getting alloc: %v", err)
+	}
+	if alloc.ID != "alloc-1" {
+		t.Fatalf("expected alloc-1, got %q", alloc.ID)
+	}
+
+	update := &structs.Allocation{
+		ID:                "alloc-1",
+		NodeID:            node.ID,
+		ClientStatus:      structs.AllocClientStatusRunning,
+		ClientDescription: "Tasks are running",
+	}
+	if err := c.NodeUpdateAlloc(context.Background(), []*structs.Allocation{update}); err != nil {
+		t.Fatalf("error updating alloc: %v", err)
+	}
+
+	got := srv.Alloc("alloc-1")
+	if got.ClientStatus != structs.AllocClientStatusRunning {
+		t.Errorf("expected client status %q, got %q", structs.AllocClientStatusRunning, got.ClientStatus)
+	}
+	if updates := srv.AllocUpdates(); len(updates) != 1 || updates[0].ID != "alloc-1" {
+		t.Errorf("expected 1 update of alloc-1, got %+v", updates)
+	}
+}
+
+func TestClient_HookError(t *testing.T) {
+	srv, c, node := newTestClient(t)
+
+	cases := []struct {
+		msg       string
+		target    error
+		retryable bool
+	}{
+		{"No cluster leader", rpc.ErrNoLeader, true},
+		{"rate limit exceeded", rpc.ErrRateLimited, true},
+		{"Permission denied", rpc.ErrPermissionDenied, false},
+		{"node secret ID does not match", rpc.ErrNodeSecretMismatch, false},
+	}
+	for _, tc := range cases {
+		srv.SetHook(func(method string) error {
+			return errors.New(tc.msg)
+		})
+		_, err := c.NodeRegister(context.Background(), node)
+		if !errors.Is(err, tc.target) {
+			t.Errorf("%q: expected %v, got %v", tc.msg, tc.target, err)
+		}
+		var srvErr *rpc.ServerError
+		if !errors.As(err, &srvErr) || srvErr.Method != "Node.Register" {
+			t.Errorf("%q: expected server error from Node.Register, got %#v", tc.msg, err)
+		}
+		if rpc.IsRetryable(err) != tc.retryable {
+			t.Errorf("%q: expected retryable %t", tc.msg, tc.retryable)
+		}
+	}
+
+	// Errors are sent in the response so the session remains usable
+	srv.SetHook(nil)
+	if _, err := c.NodeRegister(context.Background(), node); err != nil {
+		t.Fatalf("error registering after removing hook: %v", err)
+	}
+}
+
+func TestClient_HookLatency(t *testing.T) {
+	srv, c, node := newTestClient(t)
+	register(t, c, node)
+
+	srv.SetHook(func(method string) error {
+		if method == "Node.UpdateStatus" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := c.NodeUpdateStatus(ctx, structs.NodeStatusReady)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if !rpc.IsRetryable(err) {
+		t.Errorf("expected timeout to be retryable")
+	}
+
+	start := time.Now()
+	if _, err := c.NodeUpdateStatus(context.Background(), structs.NodeStatusReady); err != nil {
+		t.Fatalf("error heartbeating: %v", err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Errorf("expected latency of at least 500ms, took %s", d)
+	}
+
+	// Other methods are not delayed
+	start = time.Now()
+	if err := c.StatusPing(context.Background()); err != nil {
+		t.Fatalf("error pinging: %v", err)
+	}
+	if d := time.Since(start); d >= 500*time.Millisecond {
+		t.Errorf("expected ping to not be delayed, took %s", d)
+	}
+}
+
+// writeCA writes a self-signed CA certificate to a temporary file and returns
+// its path.
+func writeCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatalf("error writing CA: %v", err)
+	}
+	return path
+}
+
+func TestClient_DialDoesNotBlockCallers(t *testing.T) {
+	// The server accepts connections but never completes the TLS handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	var conns []net.Conn
+	var connsMu sync.Mutex
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			connsMu.Lock()
+			conns = append(conns, conn)
+			connsMu.Unlock()
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		connsMu.Lock()
+		defer connsMu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	config := structs.DefaultConfig()
+	config.Servers = []string{ln.Addr().String()}
+	config.TLS.CAFile = writeCA(t)
+	c, err := rpc.NewClient(&structs.State{NodeID: "node-1", NodeSecret: "secret-1"}, config)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	// A call willing to wait for the connection is in progress
+	slowCtx, cancelSlow := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelSlow()
+	go c.StatusPing(slowCtx)
+	time.Sleep(50 * time.Millisecond)
+
+	// Other calls give up at their own deadline rather than waiting on it
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = c.StatusPing(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected call to return at its deadline, took %s", d)
+	}
+
+	connsMu.Lock()
+	n := len(conns)
+	connsMu.Unlock()
+	if n != 1 {
+		t.Errorf("expected callers to share 1 connection attempt, got %d", n)
+	}
+}
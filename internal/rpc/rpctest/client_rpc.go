@@ -0,0 +1,104 @@
+package rpctest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/yamux"
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/ugorji/go/codec"
+)
+
+const rpcStreaming byte = 0x05
+
+// session returns the multiplexed session a node last sent RPCs over.
+func (s *Server) session(nodeID string) (*yamux.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[nodeID]
+	if !ok || session.IsClosed() {
+		return nil, fmt.Errorf("no session for node %q", nodeID)
+	}
+	return session, nil
+}
+
+// ClientRPC calls an RPC on a node by opening a stream back over the node's
+// session like real servers do.
+func (s *Server) ClientRPC(nodeID, method string, args, reply any) error {
+	session, err := s.session(nodeID)
+	if err != nil {
+		return err
+	}
+
+	stream, err := session.Open()
+	if err != nil {
+		return fmt.Errorf("error opening stream: %w", err)
+	}
+	defer stream.Close()
+
+	if _, err := stream.Write([]byte{rpcNomad}); err != nil {
+		return err
+	}
+
+	enc := codec.NewEncoder(stream, msgpackHandle)
+	dec := codec.NewDecoder(stream, msgpackHandle)
+	if err := enc.Encode(&requestHeader{ServiceMethod: method, Seq: 1}); err != nil {
+		return err
+	}
+	if err := enc.Encode(args); err != nil {
+		return err
+	}
+
+	// net/rpc responses use ServiceMethod instead of Method
+	var respHdr struct {
+		ServiceMethod string
+		Seq           uint64
+		Error         string
+	}
+	if err := dec.Decode(&respHdr); err != nil {
+		return err
+	}
+	if respHdr.Error != "" {
+		dec.Decode(&struct{}{})
+		return errors.New(respHdr.Error)
+	}
+	return dec.Decode(reply)
+}
+
+// ClientStreamingRPC opens a streaming RPC on a node and returns the stream
+// after the node acknowledges the method. The caller must send the request
+// and close the stream when done.
+func (s *Server) ClientStreamingRPC(nodeID, method string) (io.ReadWriteCloser, error) {
+	session, err := s.session(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := session.Open()
+	if err != nil {
+		return nil, fmt.Errorf("error opening stream: %w", err)
+	}
+
+	if _, err := stream.Write([]byte{rpcStreaming}); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	enc := codec.NewEncoder(stream, msgpackHandle)
+	dec := codec.NewDecoder(stream, msgpackHandle)
+	if err := enc.Encode(&rpc.StreamingRpcHeader{Method: method}); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	ack := &rpc.StreamingRpcAck{}
+	if err := dec.Decode(ack); err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if ack.Error != "" {
+		stream.Close()
+		return nil, errors.New(ack.Error)
+	}
+	return stream, nil
+}
@@ -0,0 +1,483 @@
+package rpctest
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+// endpoint decodes requests into the value returned by newRequest and passes
+// them to handle.
+type endpoint struct {
+	newRequest func() any
+	handle     func(s *Server, req any, session *yamux.Session) (any, error)
+}
+
+var endpoints = map[string]endpoint{
+	"Status.Ping": {
+		newRequest: func() any { return &rpc.QueryOptions{} },
+		handle: func(*Server, any, *yamux.Session) (any, error) {
+			return struct{}{}, nil
+		},
+	},
+	"Node.Register": {
+		newRequest: func() any { return &rpc.NodeRegisterRequest{} },
+		handle: func(s *Server, req any, session *yamux.Session) (any, error) {
+			return s.nodeRegister(req.(*rpc.NodeRegisterRequest), session)
+		},
+	},
+	"Node.UpdateStatus": {
+		newRequest: func() any { return &rpc.NodeUpdateStatusRequest{} },
+		handle: func(s *Server, req any, session *yamux.Session) (any, error) {
+			return s.nodeUpdateStatus(req.(*rpc.NodeUpdateStatusRequest), session)
+		},
+	},
+	"Node.UpdateDrain": {
+		newRequest: func() any { return &rpc.NodeUpdateDrainRequest{} },
+		handle: func(s *Server, req any, _ *yamux.Session) (any, error) {
+			return s.nodeUpdateDrain(req.(*rpc.NodeUpdateDrainRequest))
+		},
+	},
+	"Node.EmitEvents": {
+		newRequest: func() any { return &rpc.EmitNodeEventsRequest{} },
+		handle: func(s *Server, req any, _ *yamux.Session) (any, error) {
+			return s.nodeEmitEvents(req.(*rpc.EmitNodeEventsRequest))
+		},
+	},
+	"Node.GetNode": {
+		newRequest: func() any { return &rpc.NodeSpecificRequest{} },
+		handle: func(s *Server, req any, _ *yamux.Session) (any, error) {
+			return s.nodeGetNode(req.(*rpc.NodeSpecificRequest))
+		},
+	},
+	"Node.GetClientAllocs": {
+		newRequest: func() any { return &rpc.NodeSpecificRequest{} },
+		handle: func(s *Server, req any, session *yamux.Session) (any, error) {
+			return s.nodeGetClientAllocs(req.(*rpc.NodeSpecificRequest), session)
+		},
+	},
+	"Node.UpdateAlloc": {
+		newRequest: func() any { return &rpc.AllocUpdateRequest{} },
+		handle: func(s *Server, req any, _ *yamux.Session) (any, error) {
+			return s.nodeUpdateAlloc(req.(*rpc.AllocUpdateRequest))
+		},
+	},
+	"Alloc.GetAllocs": {
+		newRequest: func() any { return &rpc.AllocsGetRequest{} },
+		handle: func(s *Server, req any, _ *yamux.Session) (any, error) {
+			return s.allocGetAllocs(req.(*rpc.AllocsGetRequest))
+		},
+	},
+}
+
+// trackSession records the multiplexed session a node's RPCs arrive on. Must be
+// called with s.mu held.
+func (s *Server) trackSession(nodeID string, session *yamux.Session) {
+	if session != nil {
+		s.sessions[nodeID] = session
+	}
+}
+
+func (s *Server) nodeUpdateResponse(node *structs.Node) *rpc.NodeUpdateResponse {
+	return &rpc.NodeUpdateResponse{
+		HeartbeatTTL:          s.heartbeatTTL,
+		NodeModifyIndex:       node.ModifyIndex,
+		Servers:               s.servers,
+		SchedulingEligibility: node.SchedulingEligibility,
+		QueryMeta: rpc.QueryMeta{
+			Index:       s.index,
+			KnownLeader: true,
+		},
+	}
+}
+
+func (s *Server) nodeRegister(req *rpc.NodeRegisterRequest, session *yamux.Session) (any, error) {
+	if req.Node == nil || req.Node.ID == "" {
+		return nil, errors.New("missing node")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node := *req.Node
+	node.SchedulingEligibility = structs.NodeSchedulingEligible
+	node.DrainStrategy = nil
+	node.Events = nil
+	if existing, ok := s.nodes[node.ID]; ok {
+		if existing.SecretID != node.SecretID {
+			return nil, errors.New("node secret ID does not match. Not registering node.")
+		}
+		// Registering does not change eligibility, end drains, or clear
+		// events
+		node.SchedulingEligibility = existing.SchedulingEligibility
+		node.DrainStrategy = existing.DrainStrategy
+		node.Events = existing.Events
+	} else {
+		node.Events = []*structs.NodeEvent{{
+			Message:   "Node registered",
+			Subsystem: structs.NodeEventSubsystemCluster,
+			Timestamp: time.Now(),
+		}}
+	}
+
+	node.ModifyIndex = s.bumpIndex()
+	s.nodes[node.ID] = &node
+	s.trackSession(node.ID, session)
+	return s.nodeUpdateResponse(&node), nil
+}
+
+func (s *Server) nodeUpdateStatus(req *rpc.NodeUpdateStatusRequest, session *yamux.Session) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[req.NodeID]
+	if !ok {
+		return nil, errors.New("node not found")
+	}
+	if node.SecretID != req.AuthToken {
+		return nil, errors.New("node secret ID does not match")
+	}
+
+	s.trackSession(node.ID, session)
+	if node.Status != req.Status {
+		node.Status = req.Status
+		node.ModifyIndex = s.bumpIndex()
+	}
+	return s.nodeUpdateResponse(node), nil
+}
+
+func (s *Server) nodeUpdateDrain(req *rpc.NodeUpdateDrainRequest) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[req.NodeID]
+	if !ok {
+		return nil, errors.New("node not found")
+	}
+	if node.SecretID != req.AuthToken {
+		return nil, errors.New("node secret ID does not match")
+	}
+
+	index := s.setDrain(node, req.DrainStrategy, req.MarkEligible)
+	return &rpc.NodeDrainUpdateResponse{
+		NodeModifyIndex: index,
+		WriteMeta:       rpc.WriteMeta{Index: index},
+	}, nil
+}
+
+// setDrain starts or stops draining a node. Draining marks the node
+// ineligible. Must be called with s.mu held.
+func (s *Server) setDrain(node *structs.Node, drain *structs.DrainStrategy, markEligible bool) uint64 {
+	node.DrainStrategy = drain
+	switch {
+	case drain != nil:
+		node.SchedulingEligibility = structs.NodeSchedulingIneligible
+	case markEligible:
+		node.SchedulingEligibility = structs.NodeSchedulingEligible
+	}
+	node.ModifyIndex = s.bumpIndex()
+	return node.ModifyIndex
+}
+
+func (s *Server) nodeEmitEvents(req *rpc.EmitNodeEventsRequest) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, events := range req.NodeEvents {
+		node, ok := s.nodes[id]
+		if !ok {
+			return nil, fmt.Errorf("node %q not found", id)
+		}
+		if node.SecretID != req.AuthToken {
+			return nil, errors.New("node secret ID does not match")
+		}
+		index := s.bumpIndex()
+		for _, ev := range events {
+			e := *ev
+			e.CreateIndex = index
+			node.Events = append(node.Events, &e)
+		}
+		node.ModifyIndex = index
+	}
+	return &rpc.EmitNodeEventsResponse{WriteMeta: rpc.WriteMeta{Index: s.index}}, nil
+}
+
+func (s *Server) nodeGetNode(req *rpc.NodeSpecificRequest) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &rpc.SingleNodeResponse{
+		QueryMeta: rpc.QueryMeta{
+			Index:       s.index,
+			KnownLeader: true,
+		},
+	}
+	if node, ok := s.nodes[req.NodeID]; ok {
+		n := *node
+		resp.Node = &n
+	}
+	return resp, nil
+}
+
+func (s *Server) nodeGetClientAllocs(req *rpc.NodeSpecificRequest, session *yamux.Session) (any, error) {
+	maxWait := req.MaxQueryTime
+	if maxWait <= 0 {
+		maxWait = defaultMaxQuery
+	}
+	timeout := time.NewTimer(maxWait)
+	defer timeout.Stop()
+
+	for {
+		s.mu.Lock()
+		node, ok := s.nodes[req.NodeID]
+		if !ok {
+			s.mu.Unlock()
+			return nil, errors.New("node not found")
+		}
+		if node.SecretID != req.SecretID {
+			s.mu.Unlock()
+			return nil, errors.New("node secret ID does not match")
+		}
+		s.trackSession(node.ID, session)
+
+		if s.index > req.MinQueryIndex {
+			resp := &rpc.NodeClientAllocsResponse{
+				Allocs: map[string]uint64{},
+				QueryMeta: rpc.QueryMeta{
+					Index:       s.index,
+					KnownLeader: true,
+				},
+			}
+			for id, alloc := range s.allocs {
+				if alloc.NodeID == req.NodeID {
+					resp.Allocs[id] = alloc.AllocModifyIndex
+				}
+			}
+			s.mu.Unlock()
+			return resp, nil
+		}
+		changeCh := s.changeCh
+		index := s.index
+		s.mu.Unlock()
+
+		select {
+		case <-changeCh:
+		case <-s.shutdownCh:
+			return nil, errors.New("server shutting down")
+		case <-timeout.C:
+			return &rpc.NodeClientAllocsResponse{
+				QueryMeta: rpc.QueryMeta{
+					Index:       index,
+					KnownLeader: true,
+				},
+			}, nil
+		}
+	}
+}
+
+func (s *Server) nodeUpdateAlloc(req *rpc.AllocUpdateRequest) (any, error) {
+	if len(req.Alloc) == 0 {
+		return nil, errors.New("must update at least one allocation")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodeID := req.Alloc[0].NodeID
+	if nodeID == "" {
+		return nil, errors.New("missing node ID")
+	}
+	node, ok := s.nodes[nodeID]
+	if !ok {
+		return nil, errors.New("node not found")
+	}
+	if node.Status != "ready" {
+		return nil, fmt.Errorf("node %s is not allowed to update allocs while in status %s", nodeID, node.Status)
+	}
+
+	index := s.bumpIndex()
+	for _, update := range req.Alloc {
+		s.updates = append(s.updates, update)
+
+		alloc, ok := s.allocs[update.ID]
+		if !ok {
+			continue
+		}
+		alloc.ClientStatus = update.ClientStatus
+		alloc.ClientDescription = update.ClientDescription
+		alloc.TaskStates = update.TaskStates
+		alloc.ModifyIndex = index
+	}
+
+	return &rpc.GenericResponse{WriteMeta: rpc.WriteMeta{Index: index}}, nil
+}
+
+func (s *Server) allocGetAllocs(req *rpc.AllocsGetRequest) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := &rpc.AllocsGetResponse{
+		QueryMeta: rpc.QueryMeta{
+			Index:       s.index,
+			KnownLeader: true,
+		},
+	}
+	for _, id := range req.AllocIDs {
+		alloc, ok := s.allocs[id]
+		if !ok {
+			return nil, fmt.Errorf("alloc %q not found", id)
+		}
+		resp.Allocs = append(resp.Allocs, alloc)
+	}
+	return resp, nil
+}
+
+// Node returns a copy of a registered node or nil if it is not registered.
+func (s *Server) Node(id string) *structs.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[id]
+	if !ok {
+		return nil
+	}
+	n := *node
+	n.Events = slices.Clone(node.Events)
+	return &n
+}
+
+// DrainNode starts draining a node as `nomad node drain -enable` would, or
+// stops draining it if spec is nil. Draining does not migrate the node's
+// allocs; use MigrateAlloc.
+func (s *Server) DrainNode(id string, spec *structs.DrainSpec, markEligible bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[id]
+	if !ok {
+		return fmt.Errorf("node %q not found", id)
+	}
+
+	var drain *structs.DrainStrategy
+	if spec != nil {
+		now := time.Now()
+		drain = &structs.DrainStrategy{
+			DrainSpec:     *spec,
+			ForceDeadline: now.Add(spec.Deadline),
+			StartedAt:     now,
+		}
+	}
+	s.setDrain(node, drain, markEligible)
+	return nil
+}
+
+// DisconnectNode marks a node disconnected and its running allocs unknown as
+// servers do when a node with max_client_disconnect set misses its heartbeat.
+// The node is marked ready by its next heartbeat.
+func (s *Server) DisconnectNode(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[id]
+	if !ok {
+		return fmt.Errorf("node %q not found", id)
+	}
+	node.Status = "disconnected"
+	node.ModifyIndex = s.bumpIndex()
+
+	for _, alloc := range s.allocs {
+		if alloc.NodeID != id || alloc.ServerTerminalStatus() {
+			continue
+		}
+		switch alloc.ClientStatus {
+		case structs.AllocClientStatusComplete, structs.AllocClientStatusFailed:
+			continue
+		}
+		alloc.ClientStatus = structs.AllocClientStatusUnknown
+		alloc.ModifyIndex = s.bumpIndex()
+		alloc.AllocModifyIndex = alloc.ModifyIndex
+	}
+	return nil
+}
+
+// DeleteNode removes a node as if it was garbage collected.
+func (s *Server) DeleteNode(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.nodes, id)
+	s.bumpIndex()
+}
+
+// UpsertAlloc inserts or updates an allocation, waking any blocking
+// Node.GetClientAllocs queries for its node. The alloc's ModifyIndex and
+// AllocModifyIndex are set by the server.
+func (s *Server) UpsertAlloc(alloc *structs.Allocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := *alloc
+	a.ModifyIndex = s.bumpIndex()
+	a.AllocModifyIndex = a.ModifyIndex
+	s.allocs[a.ID] = &a
+}
+
+// StopAlloc sets an allocation's desired status to stop.
+func (s *Server) StopAlloc(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alloc, ok := s.allocs[id]
+	if !ok {
+		return fmt.Errorf("alloc %q not found", id)
+	}
+	alloc.DesiredStatus = structs.AllocDesiredStatusStop
+	alloc.ModifyIndex = s.bumpIndex()
+	alloc.AllocModifyIndex = alloc.ModifyIndex
+	return nil
+}
+
+// MigrateAlloc marks an allocation for migration as the node drainer does.
+func (s *Server) MigrateAlloc(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alloc, ok := s.allocs[id]
+	if !ok {
+		return fmt.Errorf("alloc %q not found", id)
+	}
+	migrate := true
+	alloc.DesiredTransition.Migrate = &migrate
+	alloc.ModifyIndex = s.bumpIndex()
+	alloc.AllocModifyIndex = alloc.ModifyIndex
+	return nil
+}
+
+// DeleteAlloc removes an allocation as if it was garbage collected.
+func (s *Server) DeleteAlloc(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.allocs, id)
+	s.bumpIndex()
+}
+
+// Alloc returns a copy of an allocation including any client updates or nil
+// if it does not exist.
+func (s *Server) Alloc(id string) *structs.Allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alloc, ok := s.allocs[id]
+	if !ok {
+		return nil
+	}
+	a := *alloc
+	a.TaskStates = maps.Clone(alloc.TaskStates)
+	return &a
+}
+
+// AllocUpdates returns every alloc update received via Node.UpdateAlloc in
+// the order received.
+func (s *Server) AllocUpdates() []*structs.Allocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*structs.Allocation(nil), s.updates...)
+}
@@ -0,0 +1,315 @@
+// Package rpctest provides an in-process fake Nomad server that speaks the
+// msgpack RPC protocol so clients can be exercised without a real cluster.
+package rpctest
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/schmichael/nomadlet/internal/rpc"
+	"github.com/schmichael/nomadlet/internal/structs"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	rpcNomad       byte = 0x01
+	rpcTLS         byte = 0x04
+	rpcMultiplexV2 byte = 0x06
+)
+
+const (
+	defaultMaxQuery   = 5 * time.Minute
+	defaultHeartbeat  = 10 * time.Second
+	defaultDatacenter = "dc1"
+)
+
+var msgpackHandle = rpc.NewMsgpackHandle()
+
+type requestHeader struct {
+	ServiceMethod string
+	Seq           uint64
+}
+
+type responseHeader struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+}
+
+// Hook is called before every RPC is handled. If it returns an error the error
+// is sent to the client instead of calling the endpoint. Hooks may sleep to
+// inject latency.
+type Hook func(method string) error
+
+// Server is a fake Nomad server backed by in-memory state. It is safe for
+// concurrent use.
+type Server struct {
+	// Addr is the address clients should connect to.
+	Addr string
+
+	ln      net.Listener
+	tlsConf *tls.Config
+
+	mu           sync.Mutex
+	hook         Hook
+	heartbeatTTL time.Duration
+	servers      []*rpc.NodeServerInfo
+	index        uint64
+	nodes        map[string]*structs.Node
+	allocs       map[string]*structs.Allocation
+	updates      []*structs.Allocation
+	sessions     map[string]*yamux.Session
+
+	// changeCh is closed and replaced whenever index changes to wake blocking
+	// queries.
+	changeCh chan struct{}
+
+	conns      map[net.Conn]struct{}
+	closed     bool
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewServer starts a plaintext fake server listening on a random local port.
+func NewServer() (*Server, error) {
+	return newServer(nil)
+}
+
+// NewTLSServer starts a fake server that requires clients to use TLS.
+func NewTLSServer(conf *tls.Config) (*Server, error) {
+	if conf == nil {
+		return nil, errors.New("tls config required")
+	}
+	return newServer(conf)
+}
+
+func newServer(tlsConf *tls.Config) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error listening: %w", err)
+	}
+
+	s := &Server{
+		Addr:         ln.Addr().String(),
+		ln:           ln,
+		tlsConf:      tlsConf,
+		heartbeatTTL: defaultHeartbeat,
+		index:        1,
+		nodes:        map[string]*structs.Node{},
+		allocs:       map[string]*structs.Allocation{},
+		sessions:     map[string]*yamux.Session{},
+		changeCh:     make(chan struct{}),
+		conns:        map[net.Conn]struct{}{},
+		shutdownCh:   make(chan struct{}),
+	}
+	s.servers = []*rpc.NodeServerInfo{{RPCAdvertiseAddr: s.Addr, Datacenter: defaultDatacenter}}
+
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Close stops the server and closes all client connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.shutdownCh)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+// SetHook sets the hook called before every RPC. Pass nil to remove it.
+func (s *Server) SetHook(h Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = h
+}
+
+// SetHeartbeatTTL sets the TTL returned by Node.Register and
+// Node.UpdateStatus.
+func (s *Server) SetHeartbeatTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heartbeatTTL = ttl
+}
+
+// SetServers sets the server list returned by Node.Register and
+// Node.UpdateStatus. By default only this server is returned.
+func (s *Server) SetServers(servers []*rpc.NodeServerInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers = servers
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, conn)
+				s.mu.Unlock()
+				conn.Close()
+			}()
+			s.handleConn(conn, false)
+		}()
+	}
+}
+
+// handleConn reads the magic byte and dispatches to the matching protocol.
+func (s *Server) handleConn(conn net.Conn, isTLS bool) {
+	buf := []byte{0}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return
+	}
+
+	if s.tlsConf != nil && !isTLS && buf[0] != rpcTLS {
+		// TLS is required
+		return
+	}
+
+	switch buf[0] {
+	case rpcNomad:
+		s.handleNomadConn(conn, nil)
+	case rpcTLS:
+		if s.tlsConf == nil || isTLS {
+			return
+		}
+		tlsConn := tls.Server(conn, s.tlsConf)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		s.handleConn(tlsConn, true)
+	case rpcMultiplexV2:
+		s.handleMultiplexV2(conn)
+	}
+}
+
+func (s *Server) handleMultiplexV2(conn net.Conn) {
+	conf := yamux.DefaultConfig()
+	conf.LogOutput = io.Discard
+	session, err := yamux.Server(conn, conf)
+	if err != nil {
+		return
+	}
+	defer session.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer stream.Close()
+
+			buf := []byte{0}
+			if _, err := io.ReadFull(stream, buf); err != nil || buf[0] != rpcNomad {
+				return
+			}
+			s.handleNomadConn(stream, session)
+		}()
+	}
+}
+
+// handleNomadConn serves msgpack RPCs until the connection is closed. session
+// is the multiplexed session the RPCs arrived on, or nil if not multiplexed.
+func (s *Server) handleNomadConn(conn io.ReadWriter, session *yamux.Session) {
+	dec := codec.NewDecoder(conn, msgpackHandle)
+	enc := codec.NewEncoder(conn, msgpackHandle)
+	for {
+		var hdr requestHeader
+		if err := dec.Decode(&hdr); err != nil {
+			return
+		}
+
+		resp, err := s.dispatch(hdr.ServiceMethod, dec, session)
+		respHdr := &responseHeader{
+			ServiceMethod: hdr.ServiceMethod,
+			Seq:           hdr.Seq,
+		}
+		if err != nil {
+			respHdr.Error = err.Error()
+			resp = struct{}{}
+		}
+
+		if err := enc.Encode(respHdr); err != nil {
+			return
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch decodes the request body and calls the endpoint for method.
+func (s *Server) dispatch(method string, dec *codec.Decoder, session *yamux.Session) (any, error) {
+	ep, ok := endpoints[method]
+	if !ok {
+		// Discard the body so the connection stays in sync
+		var discard any
+		if err := dec.Decode(&discard); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("rpc: can't find method %s", method)
+	}
+
+	req := ep.newRequest()
+	if err := dec.Decode(req); err != nil {
+		return nil, fmt.Errorf("error decoding %s request: %w", method, err)
+	}
+
+	s.mu.Lock()
+	hook := s.hook
+	s.mu.Unlock()
+	if hook != nil {
+		if err := hook(method); err != nil {
+			return nil, err
+		}
+	}
+
+	return ep.handle(s, req, session)
+}
+
+// bumpIndex increments the state index and wakes blocking queries. Must be
+// called with s.mu held.
+func (s *Server) bumpIndex() uint64 {
+	s.index++
+	close(s.changeCh)
+	s.changeCh = make(chan struct{})
+	return s.index
+}
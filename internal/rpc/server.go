@@ -0,0 +1,172 @@
+package rpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	netrpc "net/rpc"
+	"sync"
+
+	"github.com/hashicorp/yamux"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	// rpcStreamingMagicByte is the RpcStreaming magic byte written at the
+	// start of streams used for streaming RPCs such as logs and exec.
+	rpcStreamingMagicByte byte = 0x05
+)
+
+// StreamingHandler handles a streaming RPC. The request and all subsequent
+// messages are read from and written to conn, which is closed when the
+// handler returns.
+type StreamingHandler func(conn io.ReadWriteCloser)
+
+// Server serves RPCs that Nomad servers send to the client by opening streams
+// back over the client's multiplexed sessions.
+type Server struct {
+	rpc *netrpc.Server
+
+	streaming   map[string]StreamingHandler
+	streamingMu sync.RWMutex
+
+	log *slog.Logger
+}
+
+func NewServer(logger *slog.Logger) *Server {
+	return &Server{
+		rpc:       netrpc.NewServer(),
+		streaming: map[string]StreamingHandler{},
+		log:       logger,
+	}
+}
+
+// Register publishes the exported methods of endpoint as name.Method in the
+// style of net/rpc: methods must be of the form
+//
+//	func (e *T) Method(args *Args, reply *Reply) error
+func (s *Server) Register(name string, endpoint any) error {
+	return s.rpc.RegisterName(name, endpoint)
+}
+
+// RegisterStreaming registers a handler for a streaming RPC method.
+func (s *Server) RegisterStreaming(method string, handler StreamingHandler) {
+	s.streamingMu.Lock()
+	defer s.streamingMu.Unlock()
+	s.streaming[method] = handler
+}
+
+// serveSession accepts streams opened by the server until the session is
+// closed.
+func (s *Server) serveSession(session *yamux.Session) {
+	for {
+		stream, err := session.AcceptStream()
+		if err != nil {
+			if !session.IsClosed() {
+				s.log.Error("error accepting stream from server", "error", err)
+			}
+			return
+		}
+		go s.handleStream(stream)
+	}
+}
+
+func (s *Server) handleStream(stream *yamux.Stream) {
+	buf := []byte{0}
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		stream.Close()
+		return
+	}
+
+	switch buf[0] {
+	case rpcMagicByte:
+		// Serves requests until the stream is closed
+		s.rpc.ServeCodec(newServerCodec(stream))
+	case rpcStreamingMagicByte:
+		s.handleStreaming(stream)
+	default:
+		s.log.Error("unrecognized rpc byte from server", "byte", buf[0])
+		stream.Close()
+	}
+}
+
+func (s *Server) handleStreaming(stream *yamux.Stream) {
+	defer stream.Close()
+
+	dec := codec.NewDecoder(stream, msgpackHandle)
+	enc := codec.NewEncoder(stream, msgpackHandle)
+
+	header := &StreamingRpcHeader{}
+	if err := dec.Decode(header); err != nil {
+		s.log.Error("error decoding streaming rpc header", "error", err)
+		return
+	}
+
+	s.streamingMu.RLock()
+	handler, ok := s.streaming[header.Method]
+	s.streamingMu.RUnlock()
+
+	ack := &StreamingRpcAck{}
+	if !ok {
+		ack.Error = fmt.Sprintf("unknown rpc method: %q", header.Method)
+	}
+	if err := enc.Encode(ack); err != nil {
+		s.log.Error("error sending streaming rpc ack", "method", header.Method, "error", err)
+		return
+	}
+	if ack.Error != "" {
+		return
+	}
+
+	handler(stream)
+}
+
+// serverCodec implements net/rpc's ServerCodec using msgpack.
+type serverCodec struct {
+	rwc io.ReadWriteCloser
+	buf *bufio.Writer
+	dec *codec.Decoder
+	enc *codec.Encoder
+
+	mu sync.Mutex
+}
+
+func newServerCodec(rwc io.ReadWriteCloser) *serverCodec {
+	buf := bufio.NewWriter(rwc)
+	return &serverCodec{
+		rwc: rwc,
+		buf: buf,
+		dec: codec.NewDecoder(rwc, msgpackHandle),
+		enc: codec.NewEncoder(buf, msgpackHandle),
+	}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *netrpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *serverCodec) ReadRequestBody(body any) error {
+	if body == nil {
+		// Discard the body of requests for unknown methods
+		return c.dec.Decode(&struct{}{})
+	}
+	return c.dec.Decode(body)
+}
+
+func (c *serverCodec) WriteResponse(r *netrpc.Response, body any) error {
+	// net/rpc may write responses concurrently
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(r); err != nil {
+		return err
+	}
+	if err := c.enc.Encode(body); err != nil {
+		return err
+	}
+	return c.buf.Flush()
+}
+
+func (c *serverCodec) Close() error {
+	return c.rwc.Close()
+}
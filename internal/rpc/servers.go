@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"slices"
+)
+
+// SetServers merges the servers returned by Node.Register and
+// Node.UpdateStatus into the server list. Servers in the local datacenter are
+// tried first, followed by the rest of the cluster, followed by any seed
+// servers the cluster did not mention. The current server is kept at the
+// front of the list if it is still present to avoid needlessly reconnecting.
+//
+// Returns true if the server list changed.
+func (c *Client) SetServers(infos []*NodeServerInfo) bool {
+	if len(infos) == 0 {
+		return false
+	}
+
+	var local, remote []string
+	for _, info := range infos {
+		if info == nil || info.RPCAdvertiseAddr == "" {
+			continue
+		}
+		if info.Datacenter == c.datacenter {
+			local = append(local, info.RPCAdvertiseAddr)
+		} else {
+			remote = append(remote, info.RPCAdvertiseAddr)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	servers := mergeServers(local, remote, c.seeds)
+	if i := slices.Index(servers, c.addr); i > 0 {
+		servers = slices.Concat(servers[i:i+1], servers[:i], servers[i+1:])
+	}
+
+	if slices.Equal(servers, c.servers) {
+		return false
+	}
+	c.servers = servers
+	return true
+}
+
+// Servers returns a copy of the current server list.
+func (c *Client) Servers() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.servers)
+}
+
+// SetSeeds replaces the configured servers. Servers no longer configured are
+// dropped from the server list until servers report them again.
+func (c *Client) SetSeeds(seeds []string) {
+	seeds = mergeServers(seeds)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old := c.seeds
+	c.seeds = seeds
+	servers := slices.DeleteFunc(slices.Clone(c.servers), func(addr string) bool {
+		return slices.Contains(old, addr) && !slices.Contains(seeds, addr)
+	})
+	c.servers = mergeServers(servers, seeds)
+}
+
+// rotateServer moves the current server to the back of the list so the next
+// connection attempt uses a different server. Must be called with c.mu held.
+func (c *Client) rotateServer() {
+	if len(c.servers) < 2 {
+		return
+	}
+	c.servers = slices.Concat(c.servers[1:], c.servers[:1])
+}
+
+// mergeServers concatenates the lists of addresses, dropping empty and
+// duplicate entries while preserving order.
+func mergeServers(lists ...[]string) []string {
+	var merged []string
+	for _, l := range lists {
+		for _, addr := range l {
+			if addr == "" || slices.Contains(merged, addr) {
+				continue
+			}
+			merged = append(merged, addr)
+		}
+	}
+	return merged
+}
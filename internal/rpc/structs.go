@@ -20,6 +20,14 @@ type QueryOptions struct {
 	Region    string
 	Namespace string
 	AuthToken string
+
+	// MinQueryIndex makes the query block until the index exceeds it or
+	// MaxQueryTime elapses.
+	MinQueryIndex uint64
+	MaxQueryTime  time.Duration
+
+	// AllowStale allows any server to service the read.
+	AllowStale bool
 }
 
 type WriteRequest struct {
@@ -28,16 +36,18 @@ type WriteRequest struct {
 }
 
 type QueryMeta struct {
-	Index uint64
+	Index       uint64
+	LastContact time.Duration
+	KnownLeader bool
 }
 
 type responseHeader struct {
-	Method string
-	Seq    uint64
-	Error  string
+	ServiceMethod string
+	Seq           uint64
+	Error         string
 }
 
-type nodeRegisterRequest struct {
+type NodeRegisterRequest struct {
 	Node *structs.Node
 
 	WriteRequest
@@ -50,6 +60,7 @@ type NodeServerInfo struct {
 
 type NodeUpdateResponse struct {
 	HeartbeatTTL          time.Duration
+	NodeModifyIndex       uint64
 	Servers               []*NodeServerInfo
 	SchedulingEligibility string
 
@@ -63,13 +74,117 @@ type NodeUpdateStatusRequest struct {
 	WriteRequest
 }
 
+type NodeUpdateDrainRequest struct {
+	NodeID        string
+	DrainStrategy *structs.DrainStrategy
+	MarkEligible  bool
+	Meta          map[string]string
+	UpdatedAt     int64
+
+	WriteRequest
+}
+
+type NodeDrainUpdateResponse struct {
+	NodeModifyIndex uint64
+	EvalIDs         []string
+	EvalCreateIndex uint64
+
+	WriteMeta
+}
+
 type NodeSpecificRequest struct {
 	NodeID   string
 	SecretID string
 
+	QueryOptions
+}
+
+type EmitNodeEventsRequest struct {
+	// NodeEvents maps node IDs to their new events
+	NodeEvents map[string][]*structs.NodeEvent
+
 	WriteRequest
 }
 
+type EmitNodeEventsResponse struct {
+	WriteMeta
+}
+
+type SingleNodeResponse struct {
+	Node *structs.Node
+
+	QueryMeta
+}
+
+type NodeMetaApplyRequest struct {
+	NodeID string
+
+	// Meta maps keys to their new values. Keys set to nil are removed.
+	Meta map[string]*string
+
+	QueryOptions
+}
+
+type NodeMetaResponse struct {
+	// Meta is the node's meta: Static with Dynamic applied.
+	Meta    map[string]string
+	Dynamic map[string]*string
+	Static  map[string]string
+}
+
+// ClientHostVolumeCreateRequest asks the client to create a dynamic host
+// volume with a plugin.
+type ClientHostVolumeCreateRequest struct {
+	ID        string
+	Name      string
+	PluginID  string
+	Namespace string
+	NodeID    string
+
+	RequestedCapacityMinBytes int64
+	RequestedCapacityMaxBytes int64
+
+	// Parameters are passed to the plugin.
+	Parameters map[string]string
+}
+
+type ClientHostVolumeCreateResponse struct {
+	VolumeName    string
+	VolumeID      string
+	HostPath      string
+	CapacityBytes int64
+}
+
+// ClientHostVolumeRegisterRequest asks the client to add an existing
+// directory as a dynamic host volume without running a plugin.
+type ClientHostVolumeRegisterRequest struct {
+	ID            string
+	Name          string
+	NodeID        string
+	HostPath      string
+	CapacityBytes int64
+	Parameters    map[string]string
+}
+
+type ClientHostVolumeRegisterResponse struct{}
+
+// ClientHostVolumeDeleteRequest asks the client to delete a dynamic host
+// volume with the plugin that created it.
+type ClientHostVolumeDeleteRequest struct {
+	ID         string
+	Name       string
+	PluginID   string
+	Namespace  string
+	NodeID     string
+	HostPath   string
+	Parameters map[string]string
+}
+
+type ClientHostVolumeDeleteResponse struct {
+	VolumeName string
+	VolumeID   string
+}
+
 type NodeClientAllocsResponse struct {
 	Allocs map[string]uint64
 
@@ -85,3 +200,179 @@ type AllocsGetResponse struct {
 	Allocs []*structs.Allocation
 	QueryMeta
 }
+
+type AllocUpdateRequest struct {
+	Alloc []*structs.Allocation
+
+	WriteRequest
+}
+
+type WriteMeta struct {
+	Index uint64
+}
+
+type GenericResponse struct {
+	WriteMeta
+}
+
+// Server to client RPCs
+
+type StreamingRpcHeader struct {
+	Method string
+}
+
+type StreamingRpcAck struct {
+	Error string
+}
+
+type RpcError struct {
+	Message string
+	Code    *int64
+}
+
+func NewRpcError(err error, code *int64) *RpcError {
+	return &RpcError{
+		Message: err.Error(),
+		Code:    code,
+	}
+}
+
+func (r *RpcError) Error() string {
+	return r.Message
+}
+
+// StreamErrWrapper is used to send errors and payloads over streaming RPCs.
+type StreamErrWrapper struct {
+	Error   *RpcError
+	Payload []byte
+}
+
+type AllocSignalRequest struct {
+	AllocID string
+	Task    string
+	Signal  string
+
+	QueryOptions
+}
+
+type AllocRestartRequest struct {
+	AllocID  string
+	TaskName string
+	AllTasks bool
+
+	QueryOptions
+}
+
+type AllocStatsRequest struct {
+	AllocID string
+	Task    string
+
+	QueryOptions
+}
+
+type AllocStatsResponse struct {
+	Stats *AllocResourceUsage
+
+	QueryMeta
+}
+
+// AllocResourceUsage is the resource usage of an alloc's running tasks.
+// Timestamps are in nanoseconds since the Unix epoch.
+type AllocResourceUsage struct {
+	ResourceUsage *ResourceUsage
+	Tasks         map[string]*TaskResourceUsage
+	Timestamp     int64
+}
+
+type TaskResourceUsage struct {
+	ResourceUsage *ResourceUsage
+	Timestamp     int64
+	Pids          map[string]*ResourceUsage
+}
+
+type ResourceUsage struct {
+	MemoryStats *MemoryStats
+	CpuStats    *CpuStats
+}
+
+// MemoryStats are in bytes. Measured names the stats that are set.
+type MemoryStats struct {
+	RSS            uint64
+	Cache          uint64
+	Swap           uint64
+	MappedFile     uint64
+	Usage          uint64
+	MaxUsage       uint64
+	KernelUsage    uint64
+	KernelMaxUsage uint64
+	Measured       []string
+}
+
+// CpuStats are percentages of a core except for the tick counts. Measured
+// names the stats that are set.
+type CpuStats struct {
+	SystemMode       float64
+	UserMode         float64
+	TotalTicks       float64
+	ThrottledPeriods uint64
+	ThrottledTime    uint64
+	Percent          float64
+	Measured         []string
+}
+
+type FsLogsRequest struct {
+	AllocID   string
+	Task      string
+	LogType   string
+	Offset    int64
+	Origin    string
+	PlainText bool
+	Follow    bool
+
+	QueryOptions
+}
+
+// StreamFrame is a chunk of a streamed file. Frames are JSON encoded in
+// StreamErrWrapper payloads.
+type StreamFrame struct {
+	Offset    int64  `json:",omitempty"`
+	Data      []byte `json:",omitempty"`
+	File      string `json:",omitempty"`
+	FileEvent string `json:",omitempty"`
+}
+
+type AllocExecRequest struct {
+	AllocID string
+	Task    string
+	Tty     bool
+	Cmd     []string
+
+	QueryOptions
+}
+
+type ExecStreamingIOOperation struct {
+	Data  []byte `json:"data,omitempty"`
+	Close bool   `json:"close,omitempty"`
+}
+
+type TerminalSize struct {
+	Height int `json:"height,omitempty"`
+	Width  int `json:"width,omitempty"`
+}
+
+type ExecStreamingInput struct {
+	Stdin   *ExecStreamingIOOperation `json:"stdin,omitempty"`
+	TTYSize *TerminalSize             `json:"tty_size,omitempty"`
+}
+
+type ExecStreamingExitResult struct {
+	ExitCode int `json:"exit_code"`
+}
+
+type ExecStreamingOutput struct {
+	Stdout *ExecStreamingIOOperation `json:"stdout,omitempty"`
+	Stderr *ExecStreamingIOOperation `json:"stderr,omitempty"`
+
+	Exited bool                     `json:"exited,omitempty"`
+	Result *ExecStreamingExitResult `json:"result,omitempty"`
+}
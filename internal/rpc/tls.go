@@ -0,0 +1,128 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/schmichael/nomadlet/internal/structs"
+)
+
+const (
+	// rpcTLSMagicByte is the RpcTLS magic byte which must be sent in plaintext
+	// before the TLS handshake.
+	rpcTLSMagicByte byte = 0x04
+)
+
+// tlsConfigurator builds the TLS configuration used to dial servers and
+// allows reloading certificates without restarting.
+type tlsConfigurator struct {
+	conf   structs.TLSConfig
+	region string
+
+	tlsConf atomic.Pointer[tls.Config]
+}
+
+func newTLSConfigurator(conf structs.TLSConfig, region string) (*tlsConfigurator, error) {
+	t := &tlsConfigurator{
+		conf:   conf,
+		region: region,
+	}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Config returns the current TLS configuration.
+func (t *tlsConfigurator) Config() *tls.Config {
+	return t.tlsConf.Load()
+}
+
+// Reload reads the CA, certificate, and key files from disk. The previous
+// configuration is kept if any of the files are invalid.
+func (t *tlsConfigurator) Reload() error {
+	caPEM, err := os.ReadFile(t.conf.CAFile)
+	if err != nil {
+		return fmt.Errorf("error reading CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return fmt.Errorf("no certificates found in CA file %q", t.conf.CAFile)
+	}
+
+	tlsConf := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	switch {
+	case t.conf.CertFile != "" && t.conf.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(t.conf.CertFile, t.conf.KeyFile)
+		if err != nil {
+			return fmt.Errorf("error loading client certificate: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	case t.conf.CertFile != "" || t.conf.KeyFile != "":
+		return errors.New("both cert file and key file must be specified for mutual TLS")
+	}
+
+	if t.conf.VerifyServerHostname {
+		// Servers present certificates for server.<region>.nomad regardless of
+		// the address used to reach them.
+		tlsConf.ServerName = "server." + t.region + ".nomad"
+	} else {
+		// Verify the chain but not the hostname
+		tlsConf.InsecureSkipVerify = true
+		tlsConf.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyChain(cs, pool)
+		}
+	}
+
+	t.tlsConf.Store(tlsConf)
+	return nil
+}
+
+func verifyChain(cs tls.ConnectionState, roots *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server did not present a certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// ReloadTLS reloads the TLS configuration and certificates from disk and
+// closes the current session so the next RPC uses them. TLS is disabled if
+// conf is not enabled. The previous configuration is kept on error.
+func (c *Client) ReloadTLS(conf structs.TLSConfig) error {
+	var tlsConf *tlsConfigurator
+	if conf.Enabled() {
+		var err error
+		tlsConf, err = newTLSConfigurator(conf, c.region)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tls == nil && tlsConf == nil {
+		return nil
+	}
+	c.tls = tlsConf
+	if c.session != nil {
+		c.session.Close()
+		c.session = nil
+	}
+	return nil
+}
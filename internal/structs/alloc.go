@@ -2,18 +2,39 @@ package structs
 
 import "time"
 
+const (
+	AllocDesiredStatusRun   = "run"
+	AllocDesiredStatusStop  = "stop"
+	AllocDesiredStatusEvict = "evict"
+)
+
+const (
+	AllocClientStatusPending  = "pending"
+	AllocClientStatusRunning  = "running"
+	AllocClientStatusComplete = "complete"
+	AllocClientStatusFailed   = "failed"
+	AllocClientStatusLost     = "lost"
+	AllocClientStatusUnknown  = "unknown"
+)
+
 type Allocation struct {
 	// msgpack omit empty fields during serialization
 	_struct bool `codec:",omitempty"` // nolint: structcheck
 
 	ID        string
 	Namespace string
+	NodeID    string
 	Job       *Job
 	TaskGroup string
 
 	AllocatedResources *AllocatedResources
 
-	DesiredStatus string
+	DesiredStatus      string
+	DesiredDescription string
+
+	// DesiredTransition is set by servers to migrate allocs off draining
+	// nodes.
+	DesiredTransition DesiredTransition
 
 	ClientStatus      string
 	ClientDescription string
@@ -30,6 +51,37 @@ type Allocation struct {
 	AllocModifyIndex uint64
 }
 
+// ServerTerminalStatus returns true if the server wants the allocation
+// stopped.
+func (a *Allocation) ServerTerminalStatus() bool {
+	switch a.DesiredStatus {
+	case AllocDesiredStatusStop, AllocDesiredStatusEvict:
+		return true
+	default:
+		return false
+	}
+}
+
+// DesiredTransition is used by servers to request that a running allocation
+// transition to a new state.
+type DesiredTransition struct {
+	// Migrate is set when the allocation should be stopped and replaced on
+	// another node, such as when its node is drained.
+	Migrate *bool
+
+	// Reschedule and ForceReschedule are only used by the scheduler.
+	Reschedule      *bool
+	ForceReschedule *bool
+
+	// NoShutdownDelay skips the group's shutdown delay when stopping.
+	NoShutdownDelay *bool
+}
+
+// ShouldMigrate returns true if the allocation should be migrated.
+func (d DesiredTransition) ShouldMigrate() bool {
+	return d.Migrate != nil && *d.Migrate
+}
+
 func (a *Allocation) Group() *TaskGroup {
 	if a.Job == nil {
 		return nil
@@ -65,6 +117,12 @@ type NetworkResource struct {
 	// msgpack omit empty fields during serialization
 	_struct bool `codec:",omitempty"` // nolint: structcheck
 
+	Mode   string // Mode of the network
+	Device string // Name of the device
+	CIDR   string // CIDR block of addresses
+	IP     string // Host IP address
+	MBits  int    // Throughput
+
 	ReservedPorts []Port // Host Reserved ports
 	DynamicPorts  []Port // Host Dynamically assigned ports
 }
@@ -107,6 +165,12 @@ type AllocatedPortMapping struct {
 	IgnoreCollision bool
 }
 
+const (
+	TaskStatePending = "pending"
+	TaskStateRunning = "running"
+	TaskStateDead    = "dead"
+)
+
 type TaskState struct {
 	State       string
 	Failed      bool
@@ -114,6 +178,54 @@ type TaskState struct {
 	LastRestart time.Time
 	StartedAt   time.Time
 	FinishedAt  time.Time
+	Events      []*TaskEvent
+}
+
+// Copy returns a copy of the TaskState. Events are shared as they are never
+// mutated after being appended.
+func (ts *TaskState) Copy() *TaskState {
+	if ts == nil {
+		return nil
+	}
+	c := *ts
+	c.Events = append([]*TaskEvent(nil), ts.Events...)
+	return &c
+}
+
+const (
+	TaskReceived      = "Received"
+	TaskStarted       = "Started"
+	TaskTerminated    = "Terminated"
+	TaskSetupFailure  = "Setup Failure"
+	TaskDriverFailure = "Driver Failure"
+	TaskKilling       = "Killing"
+	TaskKilled        = "Killed"
+	TaskSignaling     = "Signaling"
+	TaskRestartSignal = "Restart Signaled"
+	TaskRestarting    = "Restarting"
+	TaskReconnected   = "Reconnected"
+	TaskRestoreFailed = "Failed Restoring Task"
+)
+
+type TaskEvent struct {
+	Type           string
+	Time           int64 // Unix Nanoseconds
+	Message        string
+	DisplayMessage string
+	Details        map[string]string
+	FailsTask      bool
+	ExitCode       int
+	Signal         int
+}
+
+func NewTaskEvent(eventType, msg string) *TaskEvent {
+	return &TaskEvent{
+		Type:           eventType,
+		Time:           time.Now().UnixNano(),
+		Message:        msg,
+		DisplayMessage: msg,
+		Details:        map[string]string{},
+	}
 }
 
 type Job struct {
@@ -143,6 +255,53 @@ type TaskGroup struct {
 	Meta          map[string]string
 	Networks      Networks
 	ShutdownDelay *time.Duration
+
+	// Volumes are the group's volume requests keyed by name.
+	Volumes map[string]*VolumeRequest
+
+	// StopAfterClientDisconnect and MaxClientDisconnect are the deprecated
+	// forms of Disconnect's StopOnClientAfter and LostAfter.
+	StopAfterClientDisconnect *time.Duration
+	MaxClientDisconnect       *time.Duration
+	Disconnect                *DisconnectStrategy
+}
+
+// DisconnectStrategy configures what happens to a group's allocs when their
+// client loses contact with the servers.
+type DisconnectStrategy struct {
+	// LostAfter is how long servers wait before marking the allocs lost.
+	// Until then they are marked unknown and left running.
+	LostAfter time.Duration
+
+	Replace   *bool
+	Reconcile string
+
+	// StopOnClientAfter is how long the client waits before stopping the
+	// allocs.
+	StopOnClientAfter *time.Duration
+}
+
+// GetDisconnectStopTimeout returns how long the client should wait after
+// losing contact with the servers before stopping the group's allocs or nil
+// if it should not stop them.
+func (tg *TaskGroup) GetDisconnectStopTimeout() *time.Duration {
+	if tg.Disconnect != nil && tg.Disconnect.StopOnClientAfter != nil {
+		return tg.Disconnect.StopOnClientAfter
+	}
+	return tg.StopAfterClientDisconnect
+}
+
+// GetDisconnectLostTimeout returns how long servers leave the group's allocs
+// running after losing contact with their client, or 0 if they are replaced
+// as soon as the client is considered down.
+func (tg *TaskGroup) GetDisconnectLostTimeout() time.Duration {
+	if tg.Disconnect != nil && tg.Disconnect.LostAfter > 0 {
+		return tg.Disconnect.LostAfter
+	}
+	if tg.MaxClientDisconnect != nil {
+		return *tg.MaxClientDisconnect
+	}
+	return 0
 }
 
 type RestartPolicy struct {
@@ -169,6 +328,40 @@ type Task struct {
 	KillSignal      string
 	KillTimeout     time.Duration
 	ShutdownDelay   time.Duration
+	VolumeMounts    []*VolumeMount
+}
+
+// Volume types
+const (
+	VolumeTypeHost = "host"
+	VolumeTypeCSI  = "csi"
+)
+
+// VolumeRequest is a group's request for a volume. For host volumes Source
+// is the name of the node's host volume.
+type VolumeRequest struct {
+	Name     string
+	Type     string
+	Source   string
+	ReadOnly bool
+}
+
+// VolumeMount mounts a group's volume into a task at Destination.
+type VolumeMount struct {
+	Volume          string
+	Destination     string
+	ReadOnly        bool
+	PropagationMode string
+}
+
+// MountConfig is a host path a task's volume mount resolved to.
+type MountConfig struct {
+	// Volume is the name of the group's volume.
+	Volume          string
+	TaskPath        string
+	HostPath        string
+	Readonly        bool
+	PropagationMode string
 }
 
 type Resources struct {
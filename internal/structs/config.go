@@ -1,16 +1,175 @@
 package structs
 
-import "os"
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
 
 type Config struct {
 	Region     string
 	Datacenter string
-	Cores      int
-	Mhz        int
-	Mem        int
-	Name       string
-	Server     string
-	StatePath  string
+
+	// Cores limits the number of detected cores used and Mhz overrides the
+	// detected total compute. 0 uses the detected values.
+	Cores int
+	Mhz   int
+
+	// Mem overrides the detected total memory in MB. 0 uses the detected
+	// memory.
+	Mem int
+
+	// Reserved resources are left for the host by the scheduler.
+	Reserved ReservedConfig
+
+	// NetworkInterface overrides the interface of the default route as the
+	// default interface. NetworkSpeed is the speed in Mbit/s of interfaces
+	// whose speed cannot be detected.
+	NetworkInterface string
+	NetworkSpeed     int
+
+	// HostNetworks are keyed by name.
+	HostNetworks map[string]*HostNetwork
+
+	// HostVolumes are host directories, keyed by name, that groups may
+	// request with host volumes.
+	HostVolumes map[string]*HostVolume
+
+	// HostVolumesDir contains the dynamic host volumes created by the mkdir
+	// plugin. HostVolumePluginDir contains dynamic host volume plugin
+	// executables.
+	HostVolumesDir      string
+	HostVolumePluginDir string
+
+	// Plugins are the task drivers' configs keyed by driver name. Each is
+	// validated by its driver.
+	Plugins map[string]map[string]any
+
+	Name      string
+	Servers   []string
+	StatePath string
+
+	// Meta is the node's static meta. It may be overridden at runtime by
+	// dynamic meta.
+	Meta      map[string]string
+	NodeClass string
+	NodePool  string
+
+	// AllocDir contains a directory per allocation for its task logs.
+	AllocDir string
+
+	TLS TLSConfig
+
+	// LogLevel is the minimum level logged: debug, info, warn, or error.
+	LogLevel string
+
+	// HTTPAddr and HTTPPort are where metrics are served. 0 disables the
+	// HTTP listener.
+	HTTPAddr string
+	HTTPPort int
+
+	// LeaveOnInterrupt and LeaveOnTerminate stop all allocations and mark
+	// the node down when receiving SIGINT or SIGTERM respectively. Otherwise
+	// tasks are left running when nomadlet exits.
+	LeaveOnInterrupt bool
+	LeaveOnTerminate bool
+
+	// DrainOnShutdown drains the node before stopping allocations when
+	// leaving.
+	DrainOnShutdown DrainConfig
+
+	// StopAfterClientDisconnect stops allocations after the client has been
+	// unable to heartbeat for this long unless their group configures its own
+	// disconnect behavior. 0 leaves them running to be reconciled when the
+	// client reconnects.
+	StopAfterClientDisconnect time.Duration
+
+	GC GCConfig
+}
+
+// ParseLogLevel parses a LogLevel.
+func ParseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("invalid log level %q; must be debug, info, warn, or error", s)
+	}
+	return l, nil
+}
+
+// ReservedConfig configures resources reserved for the host.
+type ReservedConfig struct {
+	// CPU is in MHz
+	CPU      int
+	Cores    []uint16
+	MemoryMB int
+	DiskMB   int
+
+	// Ports is a list of ports and port ranges such as "22,8000-8100".
+	Ports string
+}
+
+// HostNetwork selects the addresses that ports using the host network are
+// allocated on. Addresses must match CIDR and Interface if set.
+type HostNetwork struct {
+	CIDR      string
+	Interface string
+
+	// ReservedPorts is a list of ports and port ranges such as
+	// "22,8000-8100" that are not allocated on the host network.
+	ReservedPorts string
+}
+
+// HostVolume is a host directory exposed to tasks. Path is absolute.
+type HostVolume struct {
+	Path     string
+	ReadOnly bool
+}
+
+// GCConfig configures garbage collection of terminal allocations. Terminal
+// allocations are kept until one of the limits is exceeded.
+type GCConfig struct {
+	Interval time.Duration
+
+	// MaxAllocs is the number of allocations, including running ones, above
+	// which terminal allocations are collected.
+	MaxAllocs int
+
+	// DiskUsageThreshold and InodeUsageThreshold are the percentages of
+	// AllocDir's filesystem above which terminal allocations are collected.
+	DiskUsageThreshold  float64
+	InodeUsageThreshold float64
+
+	// MaxAge collects allocations that have been terminal for longer. 0
+	// disables collecting by age.
+	MaxAge time.Duration
+}
+
+// DrainConfig configures draining the node on shutdown. Draining is disabled
+// if Deadline is 0.
+type DrainConfig struct {
+	Deadline         time.Duration
+	IgnoreSystemJobs bool
+}
+
+func (d DrainConfig) Enabled() bool {
+	return d.Deadline > 0
+}
+
+// TLSConfig configures TLS for RPC connections to servers. TLS is enabled when
+// a CA file is set, and mutual TLS when a certificate and key are also set.
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+
+	// VerifyServerHostname requires servers to present a certificate for
+	// server.<region>.nomad.
+	VerifyServerHostname bool
+}
+
+func (t TLSConfig) Enabled() bool {
+	return t.CAFile != ""
 }
 
 func DefaultConfig() *Config {
@@ -18,11 +177,25 @@ func DefaultConfig() *Config {
 	return &Config{
 		Region:     "global",
 		Datacenter: "dc1",
-		Cores:      2,
-		Mhz:        1000,
-		Mem:        1000,
 		Name:       n,
-		Server:     "127.0.0.1:4647",
+		Servers:    []string{"127.0.0.1:4647"},
 		StatePath:  "state.json",
+		NodePool:   NodePoolDefault,
+		AllocDir:   "alloc",
+
+		HostVolumesDir:      "host_volumes",
+		HostVolumePluginDir: "host_volume_plugins",
+		TLS: TLSConfig{
+			VerifyServerHostname: true,
+		},
+		NetworkSpeed: 1000,
+		LogLevel:     "debug",
+		HTTPAddr:     "127.0.0.1",
+		GC: GCConfig{
+			Interval:            time.Minute,
+			MaxAllocs:           50,
+			DiskUsageThreshold:  80,
+			InodeUsageThreshold: 70,
+		},
 	}
 }
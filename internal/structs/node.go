@@ -2,9 +2,12 @@ package structs
 
 import (
 	"fmt"
+	"maps"
 	"os"
+	"regexp"
 	"runtime"
-	"strconv"
+	"slices"
+	"time"
 
 	"github.com/schmichael/nomadlet/version"
 )
@@ -18,13 +21,71 @@ type NodeProcessorResources struct {
 	Topology Topology
 }
 
+// Core is a logical CPU. Speeds are in MHz.
 type Core struct {
-	SocketID   uint8
-	NodeID     uint8
-	ID         uint16
+	SocketID uint8
+	NodeID   uint8
+	ID       uint16
+
+	// Disable is set for cores nomadlet may not use, such as those outside
+	// its cgroup's cpuset.
+	Disable bool
+
+	BaseSpeed  uint64
+	MaxSpeed   uint64
 	GuessSpeed uint64
 }
 
+// MHz returns the core's speed, preferring its detected max and base speeds
+// over the guess.
+func (c Core) MHz() uint64 {
+	switch {
+	case c.MaxSpeed > 0:
+		return c.MaxSpeed
+	case c.BaseSpeed > 0:
+		return c.BaseSpeed
+	default:
+		return c.GuessSpeed
+	}
+}
+
+// TotalCompute returns the node's total compute in MHz.
+func (t *Topology) TotalCompute() uint64 {
+	if t.OverrideTotalCompute > 0 {
+		return t.OverrideTotalCompute
+	}
+	var total uint64
+	for _, c := range t.Cores {
+		total += c.MHz()
+	}
+	return total
+}
+
+// UsableCompute returns the compute in MHz of the cores nomadlet may use.
+func (t *Topology) UsableCompute() uint64 {
+	if t.OverrideTotalCompute > 0 {
+		return t.OverrideTotalCompute
+	}
+	var total uint64
+	for _, c := range t.Cores {
+		if !c.Disable {
+			total += c.MHz()
+		}
+	}
+	return total
+}
+
+// UsableCores returns the number of cores nomadlet may use.
+func (t *Topology) UsableCores() int {
+	n := 0
+	for _, c := range t.Cores {
+		if !c.Disable {
+			n++
+		}
+	}
+	return n
+}
+
 type NodeDiskResources struct {
 	DiskMB int64
 }
@@ -36,12 +97,88 @@ type NodeResources struct {
 	Disk       NodeDiskResources
 	Memory     NodeMemoryResources
 	Processors NodeProcessorResources
+
+	// Networks is the default network and NodeNetworks all of the host's
+	// addresses that ports may be allocated on.
+	Networks     Networks
+	NodeNetworks []*NodeNetworkResource
+}
+
+const (
+	NodeNetworkModeHost = "host"
+
+	NodeNetworkAFIPv4 = "ipv4"
+	NodeNetworkAFIPv6 = "ipv6"
+
+	// NodeNetworkAliasDefault is the host network of the addresses of the
+	// default interface. Ports without a host network are allocated on it.
+	NodeNetworkAliasDefault = "default"
+)
+
+// NodeNetworkResource is a network interface and its addresses.
+type NodeNetworkResource struct {
+	Mode       string
+	Device     string
+	MacAddress string
+
+	// Speed is in Mbit/s
+	Speed int
+
+	Addresses []NodeNetworkAddress
+}
+
+// NodeNetworkAddress is an address and the host network it belongs to. An
+// address in several host networks is listed once per host network.
+type NodeNetworkAddress struct {
+	Family        string
+	Alias         string
+	Address       string
+	ReservedPorts string
+	Gateway       string
 }
 
 type NodeMemoryResources struct {
 	MemoryMB int64
 }
 
+type NodeReservedResources struct {
+	Cpu      NodeReservedCpuResources
+	Memory   NodeReservedMemoryResources
+	Disk     NodeReservedDiskResources
+	Networks NodeReservedNetworkResources
+}
+
+type NodeReservedCpuResources struct {
+	CpuShares        uint64
+	ReservedCpuCores []uint16
+}
+
+type NodeReservedMemoryResources struct {
+	MemoryMB uint64
+}
+
+type NodeReservedDiskResources struct {
+	DiskMB uint64
+}
+
+type NodeReservedNetworkResources struct {
+	// ReservedHostPorts is a list of ports and port ranges such as
+	// "22,8000-8100".
+	ReservedHostPorts string
+}
+
+const (
+	NodeStatusInit  = "initializing"
+	NodeStatusReady = "ready"
+	NodeStatusDown  = "down"
+
+	NodeSchedulingEligible   = "eligible"
+	NodeSchedulingIneligible = "ineligible"
+
+	// NodePoolDefault is the node pool of nodes that do not configure one.
+	NodePoolDefault = "default"
+)
+
 type Node struct {
 	ID         string
 	SecretID   string
@@ -49,10 +186,139 @@ type Node struct {
 	Name       string
 	Status     string
 
+	// SchedulingEligibility and DrainStrategy are owned by the servers and
+	// ignored when registering.
+	SchedulingEligibility string
+	DrainStrategy         *DrainStrategy
+
 	Attributes map[string]string
 	Drivers    map[string]*DriverInfo
 
+	// Meta is the configured meta with the dynamic meta applied at runtime
+	// merged over it.
+	Meta      map[string]string
+	NodeClass string
+	NodePool  string
+
 	NodeResources *NodeResources
+
+	// HostVolumes are keyed by name.
+	HostVolumes map[string]*ClientHostVolumeConfig
+
+	// ReservedResources are reserved for the host. Servers subtract them
+	// from NodeResources when scheduling.
+	ReservedResources *NodeReservedResources
+
+	// Events are owned by the servers and ignored when registering. Use
+	// Node.EmitEvents to add events.
+	Events []*NodeEvent
+
+	ModifyIndex uint64
+}
+
+// Copy returns a deep copy of the node so it may be modified without affecting
+// n. Events are owned by the servers and are shared.
+func (n *Node) Copy() *Node {
+	c := *n
+	c.Attributes = maps.Clone(n.Attributes)
+	c.Meta = maps.Clone(n.Meta)
+	if n.Drivers != nil {
+		c.Drivers = make(map[string]*DriverInfo, len(n.Drivers))
+		for name, d := range n.Drivers {
+			c.Drivers[name] = d.Copy()
+		}
+	}
+	if n.HostVolumes != nil {
+		c.HostVolumes = make(map[string]*ClientHostVolumeConfig, len(n.HostVolumes))
+		for name, v := range n.HostVolumes {
+			vc := *v
+			c.HostVolumes[name] = &vc
+		}
+	}
+	c.NodeResources = n.NodeResources.Copy()
+	c.ReservedResources = n.ReservedResources.Copy()
+	if n.DrainStrategy != nil {
+		ds := *n.DrainStrategy
+		c.DrainStrategy = &ds
+	}
+	return &c
+}
+
+// Copy returns a deep copy of the resources.
+func (r *NodeResources) Copy() *NodeResources {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Processors.Topology.Cores = slices.Clone(r.Processors.Topology.Cores)
+	if r.Networks != nil {
+		c.Networks = make(Networks, len(r.Networks))
+		for i, n := range r.Networks {
+			nc := *n
+			nc.ReservedPorts = slices.Clone(n.ReservedPorts)
+			nc.DynamicPorts = slices.Clone(n.DynamicPorts)
+			c.Networks[i] = &nc
+		}
+	}
+	if r.NodeNetworks != nil {
+		c.NodeNetworks = make([]*NodeNetworkResource, len(r.NodeNetworks))
+		for i, n := range r.NodeNetworks {
+			nc := *n
+			nc.Addresses = slices.Clone(n.Addresses)
+			c.NodeNetworks[i] = &nc
+		}
+	}
+	return &c
+}
+
+// Copy returns a deep copy of the reserved resources.
+func (r *NodeReservedResources) Copy() *NodeReservedResources {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Cpu.ReservedCpuCores = slices.Clone(r.Cpu.ReservedCpuCores)
+	return &c
+}
+
+// ClientHostVolumeConfig is a host volume advertised to servers. ID is only
+// set for dynamic host volumes.
+type ClientHostVolumeConfig struct {
+	Name     string
+	Path     string
+	ReadOnly bool
+	ID       string
+}
+
+// NodeEvent is an event shown in the node's history.
+type NodeEvent struct {
+	Message     string
+	Subsystem   string
+	Details     map[string]string
+	Timestamp   time.Time
+	CreateIndex uint64
+}
+
+// Node event subsystems
+const (
+	NodeEventSubsystemCluster = "Cluster"
+	NodeEventSubsystemDrain   = "Drain"
+	NodeEventSubsystemDriver  = "Driver"
+)
+
+// DrainSpec is the operator supplied part of a drain.
+type DrainSpec struct {
+	Deadline         time.Duration
+	IgnoreSystemJobs bool
+}
+
+// DrainStrategy describes a node drain in progress. The servers migrate the
+// node's allocs and force any remaining allocs to migrate at ForceDeadline.
+type DrainStrategy struct {
+	DrainSpec
+
+	ForceDeadline time.Time
+	StartedAt     time.Time
 }
 
 func MakeNode(state *State, config *Config) (*Node, error) {
@@ -61,59 +327,116 @@ func MakeNode(state *State, config *Config) (*Node, error) {
 		return nil, fmt.Errorf("error determining node name: %w", err)
 	}
 
+	// Processors, memory, disk, and drivers are fingerprinted
 	nr := &NodeResources{
-		Disk: NodeDiskResources{
-			DiskMB: 2000, //TODO ¯\_(ツ)_/¯
-		},
 		MinDynamicPort: 20000,
 		MaxDynamicPort: 32000,
-		Memory: NodeMemoryResources{
-			MemoryMB: int64(config.Mem),
+	}
+
+	reserved := config.Reserved
+	rr := &NodeReservedResources{
+		Cpu: NodeReservedCpuResources{
+			CpuShares:        uint64(reserved.CPU),
+			ReservedCpuCores: reserved.Cores,
+		},
+		Memory: NodeReservedMemoryResources{
+			MemoryMB: uint64(reserved.MemoryMB),
+		},
+		Disk: NodeReservedDiskResources{
+			DiskMB: uint64(reserved.DiskMB),
 		},
-		Processors: NodeProcessorResources{
-			Topology: Topology{
-				Cores: []Core{
-					{
-						GuessSpeed: uint64(config.Mhz),
-					},
-				},
-				OverrideTotalCompute: uint64(config.Mhz),
-			},
+		Networks: NodeReservedNetworkResources{
+			ReservedHostPorts: reserved.Ports,
 		},
 	}
 
+	// Configured host volumes take precedence over dynamic host volumes of
+	// the same name
+	hostVolumes := make(map[string]*ClientHostVolumeConfig, len(config.HostVolumes)+len(state.HostVolumes))
+	for _, hv := range state.HostVolumes {
+		hostVolumes[hv.Name] = &ClientHostVolumeConfig{
+			Name: hv.Name,
+			Path: hv.HostPath,
+			ID:   hv.ID,
+		}
+	}
+	for name, hv := range config.HostVolumes {
+		hostVolumes[name] = &ClientHostVolumeConfig{
+			Name:     name,
+			Path:     hv.Path,
+			ReadOnly: hv.ReadOnly,
+		}
+	}
+
 	return &Node{
 		ID:         state.NodeID,
 		SecretID:   state.NodeSecret,
 		Datacenter: config.Datacenter,
 		Name:       config.Name,
-		Status:     "initializing",
+		Status:     NodeStatusInit,
+
+		SchedulingEligibility: NodeSchedulingEligible,
 		Attributes: map[string]string{
 			"cpu.arch":                runtime.GOARCH,
-			"cpu.totalcompute":        strconv.Itoa(config.Mhz),
-			"cpu.usablecompute":       strconv.Itoa(config.Mhz),
 			"kernel.name":             runtime.GOOS,
-			"memory.totalbytes":       strconv.Itoa(config.Mem * 1024 * 1024),
 			"nomad.service_discovery": "false",
 			"os.signals":              "SIGSEGV,SIGSTOP,SIGSYS,SIGWINCH,SIGNULL,SIGALRM,SIGBUS,SIGHUP,SIGILL,SIGIO,SIGTRAP,SIGTSTP,SIGFPE,SIGKILL,SIGPROF,SIGTERM,SIGTTIN,SIGUSR1,SIGUSR2,SIGXCPU,SIGABRT,SIGINT,SIGTTOU,SIGXFSZ,SIGCONT,SIGIOT,SIGPIPE,SIGQUIT",
 			"unique.hostname":         hostname,
 			"nomadlet.version":        version.Version,
 		},
-		Drivers: map[string]*DriverInfo{
-			"raw_exec": &DriverInfo{
-				Detected:          true,
-				Healthy:           true,
-				HealthDescription: "never felt better",
-			},
-		},
 
-		NodeResources: nr,
+		Meta:      MergeMeta(config.Meta, state.DynamicMeta),
+		NodeClass: config.NodeClass,
+		NodePool:  config.NodePool,
+
+		NodeResources:     nr,
+		HostVolumes:       hostVolumes,
+		ReservedResources: rr,
 	}, nil
 }
 
+// validNodePoolName matches the node pool names accepted by servers.
+var validNodePoolName = regexp.MustCompile("^[a-zA-Z0-9-_]{1,128}$")
+
+// ValidateNodePool returns an error if servers would reject the node pool
+// name.
+func ValidateNodePool(name string) error {
+	if !validNodePoolName.MatchString(name) {
+		return fmt.Errorf("invalid node pool %q; must be 1-128 letters, numbers, dashes, or underscores", name)
+	}
+	return nil
+}
+
+// MergeMeta returns the static meta with the dynamic meta applied. Dynamic
+// meta set to nil removes the key.
+func MergeMeta(static map[string]string, dynamic map[string]*string) map[string]string {
+	meta := maps.Clone(static)
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	for k, v := range dynamic {
+		if v == nil {
+			delete(meta, k)
+		} else {
+			meta[k] = *v
+		}
+	}
+	return meta
+}
+
 type DriverInfo struct {
 	Attributes        map[string]string
 	Detected          bool
 	Healthy           bool
 	HealthDescription string
 }
+
+// Copy returns a deep copy of the driver info.
+func (d *DriverInfo) Copy() *DriverInfo {
+	if d == nil {
+		return nil
+	}
+	c := *d
+	c.Attributes = maps.Clone(d.Attributes)
+	return &c
+}
@@ -0,0 +1,65 @@
+package structs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseCPUList parses the kernel's cpu list format such as "0-3,8,10-11".
+func ParseCPUList(s string) ([]int, error) {
+	var ids []int
+	err := parseRanges(s, func(start, end int) {
+		for id := start; id <= end; id++ {
+			ids = append(ids, id)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid cpu list %q", s)
+	}
+	return ids, nil
+}
+
+// ParsePortRanges parses a list of ports and port ranges such as
+// "22,8000-8100".
+func ParsePortRanges(s string) ([]int, error) {
+	var ports []int
+	valid := true
+	err := parseRanges(s, func(start, end int) {
+		if start < 1 || end > 65535 {
+			valid = false
+			return
+		}
+		for p := start; p <= end; p++ {
+			ports = append(ports, p)
+		}
+	})
+	if err != nil || !valid {
+		return nil, fmt.Errorf("invalid port list %q; must be ports or ranges between 1 and 65535 such as 22,8000-8100", s)
+	}
+	return ports, nil
+}
+
+// parseRanges calls fn with the inclusive bounds of each comma separated
+// number or range in s.
+func parseRanges(s string, fn func(start, end int)) error {
+	for _, r := range strings.Split(strings.TrimSpace(s), ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(r, "-")
+		start, err := strconv.Atoi(lo)
+		if err != nil || start < 0 {
+			return fmt.Errorf("invalid number %q", lo)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(hi); err != nil || end < start {
+				return fmt.Errorf("invalid range %q", r)
+			}
+		}
+		fn(start, end)
+	}
+	return nil
+}
@@ -6,11 +6,58 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type State struct {
 	NodeID     string `json:"node_id"`
 	NodeSecret string `json:"node_secret"`
+
+	// Servers learned from the cluster so the client can reconnect even if
+	// the configured servers are gone.
+	Servers []string `json:"servers,omitempty"`
+
+	// DynamicMeta is the node meta applied at runtime. Keys set to nil remove
+	// configured meta.
+	DynamicMeta map[string]*string `json:"dynamic_meta,omitempty"`
+
+	// HostVolumes are the dynamic host volumes keyed by volume ID.
+	HostVolumes map[string]*HostVolumeState `json:"host_volumes,omitempty"`
+}
+
+// HostVolumeState is a dynamic host volume created by a plugin or
+// registered by servers. PluginID is empty for registered volumes.
+type HostVolumeState struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	PluginID      string `json:"plugin_id,omitempty"`
+	HostPath      string `json:"host_path"`
+	CapacityBytes int64  `json:"capacity_bytes,omitempty"`
+}
+
+// AllocState is the client's local state for an allocation. Each alloc's
+// state is stored in its own file so task events only rewrite their alloc's
+// state.
+type AllocState struct {
+	Alloc *Allocation                `json:"alloc"`
+	Tasks map[string]*TaskLocalState `json:"tasks"`
+}
+
+// TaskLocalState is the client's local state for a task.
+type TaskLocalState struct {
+	State *TaskState `json:"state"`
+
+	// Handle is set while the task's process is running.
+	Handle *TaskHandle `json:"handle,omitempty"`
+}
+
+// TaskHandle identifies a task's process. StartTime guards against
+// reattaching to an unrelated process that reused the task's PID while
+// nomadlet was down.
+type TaskHandle struct {
+	PID       int    `json:"pid"`
+	StartTime uint64 `json:"start_time"`
 }
 
 func StateLoad(path string) (*State, error) {
@@ -32,7 +79,64 @@ func StateLoad(path string) (*State, error) {
 }
 
 func (s *State) Store(path string) error {
-	buf, err := json.MarshalIndent(s, "", "  ")
+	return storeJSON(path, s)
+}
+
+// AllocStatesLoad returns the alloc states stored in dir keyed by alloc ID.
+// States that cannot be read are nil so one corrupt file does not prevent
+// restoring other allocs.
+func AllocStatesLoad(dir string) (map[string]*AllocState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	states := map[string]*AllocState{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		states[id] = nil
+		buf, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		as := &AllocState{}
+		if err := json.Unmarshal(buf, as); err == nil {
+			states[id] = as
+		}
+	}
+	return states, nil
+}
+
+// Store writes the alloc's state to its file in dir, creating dir if needed.
+func (as *AllocState) Store(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	return storeJSON(allocStatePath(dir, as.Alloc.ID), as)
+}
+
+// AllocStateDelete removes an alloc's state file from dir.
+func AllocStateDelete(dir, allocID string) error {
+	err := os.Remove(allocStatePath(dir, allocID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func allocStatePath(dir, allocID string) string {
+	return filepath.Join(dir, allocID+".json")
+}
+
+// storeJSON atomically replaces the file at path with v encoded as JSON.
+func storeJSON(path string, v any) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -4,37 +4,35 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	client "github.com/schmichael/nomadlet/client"
+	agentconfig "github.com/schmichael/nomadlet/internal/config"
 	"github.com/schmichael/nomadlet/internal/structs"
 	"github.com/schmichael/nomadlet/version"
 )
 
-func main() {
-	config := structs.DefaultConfig()
-
-	flag.IntVar(&config.Cores, "cores", config.Cores, "number of cores")
-	flag.IntVar(&config.Mhz, "mhz", config.Mhz, "total mhz available")
-	flag.IntVar(&config.Mem, "mem", config.Mem, "total memory in MB")
-	flag.StringVar(&config.Region, "region", config.Region, "region")
-	flag.StringVar(&config.Datacenter, "dc", config.Datacenter, "datacenter")
-	flag.StringVar(&config.Server, "server", config.Server, "server address")
-	flag.StringVar(&config.StatePath, "state", config.StatePath, "state file path")
-	flag.StringVar(&config.Name, "name", config.Name, "node name")
-	//TODO tls stuff
-	//TODO multi-server handling
-
-	versionFlag := false
-	flag.BoolVar(&versionFlag, "version", versionFlag, "print version and exit")
-
-	flag.Parse()
+// shutdownGracePeriod bounds how long stopping allocs and sending final updates
+// may take after any drain.
+const shutdownGracePeriod = time.Minute
 
+func main() {
+	config, versionFlag, err := parseConfig(os.Args[1:], flag.ExitOnError)
 	if versionFlag {
 		fmt.Println("nomadlet " + version.Version)
 		os.Exit(0)
 	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
 	if config.Name == "" {
 		fmt.Fprintf(os.Stderr, "must specify node name")
@@ -47,12 +45,181 @@ func main() {
 		os.Exit(1)
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
+	if config.HTTPPort != 0 {
+		addr := net.JoinHostPort(config.HTTPAddr, strconv.Itoa(config.HTTPPort))
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error listening for HTTP: %v\n", err)
+			os.Exit(1)
+		}
+		srv := &http.Server{Handler: client.HTTPHandler(), ReadHeaderTimeout: 10 * time.Second}
+		go srv.Serve(ln)
+		defer srv.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			newConfig, _, err := parseConfig(os.Args[1:], flag.ContinueOnError)
+			if err == nil {
+				err = client.Reload(newConfig)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error reloading: %v\n", err)
+			}
+		}
+	}()
+
 	doneCh := make(chan struct{})
 	go func() {
 		defer close(doneCh)
 		client.Run(ctx)
 	}()
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	var sig os.Signal
+	select {
+	case <-doneCh:
+		return
+	case sig = <-sigCh:
+	}
+
+	// Use the settings from the last reload
+	config = client.Config()
+	leave := config.LeaveOnInterrupt
+	if sig == syscall.SIGTERM {
+		leave = config.LeaveOnTerminate
+	}
+
+	// Allow time to drain and then to stop allocs; a second signal skips the
+	// graceful shutdown.
+	timeout := config.DrainOnShutdown.Deadline + shutdownGracePeriod
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), timeout)
+	defer shutdownCancel()
+	go func() {
+		select {
+		case <-sigCh:
+			fmt.Fprintln(os.Stderr, "received second signal; exiting")
+			shutdownCancel()
+		case <-shutdownCtx.Done():
+		}
+	}()
+
+	client.Shutdown(shutdownCtx, leave)
+	cancel()
 	<-doneCh
 }
+
+// parseConfig builds the agent configuration from the defaults, then any
+// config files, then the flags in args, so flags override config files.
+func parseConfig(args []string, errorHandling flag.ErrorHandling) (*structs.Config, bool, error) {
+	config := structs.DefaultConfig()
+	fs := flag.NewFlagSet(os.Args[0], errorHandling)
+
+	fs.IntVar(&config.Cores, "cores", config.Cores, "limit the number of detected cores used; 0 uses all")
+	fs.IntVar(&config.Mhz, "mhz", config.Mhz, "override the detected total compute in MHz; 0 detects")
+	fs.IntVar(&config.Mem, "mem", config.Mem, "override the detected total memory in MB; 0 detects")
+	fs.StringVar(&config.Region, "region", config.Region, "region")
+	fs.StringVar(&config.Datacenter, "dc", config.Datacenter, "datacenter")
+	serversSet := false
+	fs.Func("server", "server address; may be comma separated or repeated (default 127.0.0.1:4647)", func(v string) error {
+		if !serversSet {
+			// Replace the default
+			config.Servers = nil
+			serversSet = true
+		}
+		for _, addr := range strings.Split(v, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				config.Servers = append(config.Servers, addr)
+			}
+		}
+		return nil
+	})
+	fs.StringVar(&config.StatePath, "state", config.StatePath, "state file path")
+	fs.StringVar(&config.Name, "name", config.Name, "node name")
+	fs.Func("meta", "node meta as key=value; may be repeated", func(v string) error {
+		k, val, ok := strings.Cut(v, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("invalid meta %q; must be key=value", v)
+		}
+		if config.Meta == nil {
+			config.Meta = map[string]string{}
+		}
+		config.Meta[k] = val
+		return nil
+	})
+	fs.StringVar(&config.NodeClass, "node-class", config.NodeClass, "node class")
+	fs.Func("node-pool", "node pool (default "+structs.NodePoolDefault+")", func(v string) error {
+		if err := structs.ValidateNodePool(v); err != nil {
+			return err
+		}
+		config.NodePool = v
+		return nil
+	})
+	fs.StringVar(&config.NetworkInterface, "network-interface", config.NetworkInterface, "default network interface (default is the default route's interface)")
+	fs.StringVar(&config.AllocDir, "alloc-dir", config.AllocDir, "directory for alloc logs")
+	fs.Func("log-level", "log level: debug, info, warn, or error (default debug)", func(v string) error {
+		if _, err := structs.ParseLogLevel(v); err != nil {
+			return err
+		}
+		config.LogLevel = v
+		return nil
+	})
+	fs.StringVar(&config.TLS.CAFile, "ca-file", config.TLS.CAFile, "CA certificate file; enables TLS")
+	fs.StringVar(&config.TLS.CertFile, "cert-file", config.TLS.CertFile, "client certificate file for mutual TLS")
+	fs.StringVar(&config.TLS.KeyFile, "key-file", config.TLS.KeyFile, "client key file for mutual TLS")
+	fs.BoolVar(&config.TLS.VerifyServerHostname, "verify-server-hostname", config.TLS.VerifyServerHostname, "verify servers present a certificate for server.<region>.nomad")
+
+	fs.StringVar(&config.HTTPAddr, "http-addr", config.HTTPAddr, "address to serve metrics on")
+	fs.IntVar(&config.HTTPPort, "http-port", config.HTTPPort, "port to serve metrics on; 0 disables")
+
+	fs.BoolVar(&config.LeaveOnInterrupt, "leave-on-interrupt", config.LeaveOnInterrupt, "stop allocs and mark the node down on SIGINT instead of leaving tasks running")
+	fs.BoolVar(&config.LeaveOnTerminate, "leave-on-terminate", config.LeaveOnTerminate, "stop allocs and mark the node down on SIGTERM instead of leaving tasks running")
+	fs.DurationVar(&config.DrainOnShutdown.Deadline, "drain-on-shutdown", config.DrainOnShutdown.Deadline, "drain the node with this deadline before stopping allocs when leaving")
+	fs.BoolVar(&config.DrainOnShutdown.IgnoreSystemJobs, "drain-ignore-system-jobs", config.DrainOnShutdown.IgnoreSystemJobs, "do not drain system job allocs when draining on shutdown")
+
+	fs.DurationVar(&config.StopAfterClientDisconnect, "stop-after-client-disconnect", config.StopAfterClientDisconnect, "stop allocs without their own disconnect behavior after failing to heartbeat for this long; 0 leaves them running")
+
+	fs.DurationVar(&config.GC.Interval, "gc-interval", config.GC.Interval, "interval between garbage collecting terminal allocs")
+	fs.IntVar(&config.GC.MaxAllocs, "gc-max-allocs", config.GC.MaxAllocs, "collect terminal allocs when there are more allocs than this")
+	fs.Float64Var(&config.GC.DiskUsageThreshold, "gc-disk-usage-threshold", config.GC.DiskUsageThreshold, "collect terminal allocs when disk usage exceeds this percent")
+	fs.Float64Var(&config.GC.InodeUsageThreshold, "gc-inode-usage-threshold", config.GC.InodeUsageThreshold, "collect terminal allocs when inode usage exceeds this percent")
+	fs.DurationVar(&config.GC.MaxAge, "gc-max-age", config.GC.MaxAge, "collect allocs terminal for longer than this; 0 disables")
+
+	var configPaths []string
+	configLoaded := false
+	fs.Func("config", "config file or directory of .hcl and .json files; may be repeated and later files override earlier ones", func(v string) error {
+		if !configLoaded {
+			configPaths = append(configPaths, v)
+		}
+		return nil
+	})
+
+	versionFlag := false
+	fs.BoolVar(&versionFlag, "version", versionFlag, "print version and exit")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if versionFlag || len(configPaths) == 0 {
+		return config, versionFlag, nil
+	}
+
+	// Load the files found by the first pass and then parse the flags again
+	// on top of them.
+	if err := agentconfig.Load(config, configPaths...); err != nil {
+		return nil, false, err
+	}
+	configLoaded = true
+	serversSet = false
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	return config, false, nil
+}